@@ -0,0 +1,123 @@
+package md5summer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessLog, if set, receives a line for every file the tool opens,
+// reads, writes and closes, and every directory it lists. It is set up
+// by -access-log.
+var accessLog *accessLogger
+
+type accessLogger struct {
+	lk sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+func newAccessLogger(name string) (*accessLogger, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogger{w: redactOutput(f), f: f}, nil
+}
+
+// record writes one access as a line of the form
+//
+//	2006-01-02T15:04:05.999999999Z op path="..." bytes=N result="ok"
+func (l *accessLogger) record(op, path string, bytes int64, err error) {
+	if l == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	line := fmt.Sprintf("%s %s path=%s bytes=%d result=%s\n",
+		time.Now().UTC().Format(time.RFC3339Nano), op, strconv.Quote(path), bytes, strconv.Quote(result))
+	l.lk.Lock()
+	io.WriteString(l.w, line)
+	l.lk.Unlock()
+}
+
+func (l *accessLogger) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// auditedFile is an open file whose use is recorded in the access log.
+// Opening it is recorded straight away, the bytes read or written and
+// the first error are recorded when it is closed.
+type auditedFile struct {
+	*os.File
+	// guards bytes and err, ReadAt may be called from several goroutines
+	lk    sync.Mutex
+	bytes int64
+	err   error
+}
+
+// openFile opens a file like os.OpenFile and records it in the access
+// log, as a create if flag holds os.O_CREATE.
+func openFile(path string, flag int, perm os.FileMode) (*auditedFile, error) {
+	op := "open"
+	if flag&os.O_CREATE != 0 {
+		op = "create"
+	}
+	f, err := os.OpenFile(path, flag, perm)
+	accessLog.record(op, path, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &auditedFile{File: f}, nil
+}
+
+// openRead opens a file for reading like os.Open.
+func openRead(path string) (*auditedFile, error) {
+	return openFile(path, os.O_RDONLY, 0)
+}
+
+// count adds n bytes and, if it is the first, the error err to the
+// record of the file.
+func (f *auditedFile) count(n int, err error) {
+	f.lk.Lock()
+	f.bytes += int64(n)
+	if err != nil && err != io.EOF && f.err == nil {
+		f.err = err
+	}
+	f.lk.Unlock()
+}
+
+func (f *auditedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.count(n, err)
+	return n, err
+}
+
+func (f *auditedFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.count(n, err)
+	return n, err
+}
+
+func (f *auditedFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.count(n, err)
+	return n, err
+}
+
+func (f *auditedFile) Close() error {
+	err := f.File.Close()
+	if f.err == nil {
+		f.err = err
+	}
+	accessLog.record("close", f.Name(), f.bytes, f.err)
+	return err
+}
@@ -0,0 +1,171 @@
+package md5summer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVersion is bumped whenever the layout of the state cache changes,
+// older caches are then started over.
+const cacheVersion = 1
+
+// racyWindow is how recently a file may have been modified and still be
+// cached. A file changed again within the granularity of its mtime right
+// after it was read would otherwise keep a stale digest forever.
+const racyWindow = 2 * time.Second
+
+// cacheEntry is the digest of a file as it was when it was read.
+type cacheEntry struct {
+	Size  int64
+	Mtime time.Time
+	Algo  string
+	Sum   []byte
+}
+
+// cacheFile is the contents of a state cache file.
+type cacheFile struct {
+	Version int
+	Entries map[string]cacheEntry
+}
+
+// stateCache keeps the digests of earlier runs, set up by -cache, so a
+// file whose size and modification time haven't changed isn't read
+// again. A nil stateCache caches nothing.
+type stateCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// paths looked up or stored by this run
+	seen map[string]bool
+}
+
+// newStateCache returns an empty cache.
+func newStateCache() *stateCache {
+	return &stateCache{entries: make(map[string]cacheEntry), seen: make(map[string]bool)}
+}
+
+// loadStateCache reads the cache in the file name, a missing file or one
+// of another version being an empty cache.
+func loadStateCache(name string) (*stateCache, error) {
+	c := newStateCache()
+	f, err := openRead(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cf cacheFile
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&cf); err != nil {
+		return nil, fmt.Errorf("corrupt cache: %v", err)
+	}
+	if cf.Version == cacheVersion && cf.Entries != nil {
+		c.entries = cf.Entries
+	}
+	return c, nil
+}
+
+// lookup returns the cached digest of path, if the file still has the
+// size and modification time it had when it was read.
+func (c *stateCache) lookup(path string, info os.FileInfo, algo string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[path] = true
+	e, ok := c.entries[path]
+	if !ok || e.Algo != algo || e.Size != info.Size() || !e.Mtime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return e.Sum, true
+}
+
+// store caches the digest of path, read when the file was as in info,
+// now being the time it was read.
+func (c *stateCache) store(path string, info os.FileInfo, algo string, sum []byte, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[path] = true
+	if now.Sub(info.ModTime()) < racyWindow {
+		delete(c.entries, path)
+		return
+	}
+	c.entries[path] = cacheEntry{info.Size(), info.ModTime(), algo, sum}
+}
+
+// forget drops path, whose file is gone.
+func (c *stateCache) forget(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+	delete(c.seen, path)
+}
+
+// save writes the cache to the file name. With prune, the entries below
+// root that this run didn't come across are dropped, their files are
+// gone or excluded.
+func (c *stateCache) save(name, root string, prune bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prune {
+		for path := range c.entries {
+			below := path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+			if below && !c.seen[path] {
+				delete(c.entries, path)
+			}
+		}
+	}
+	return writeAtomic(name, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		if err := gob.NewEncoder(bw).Encode(cacheFile{cacheVersion, c.entries}); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+// unchangedSince reports whether a walk of root with opts would write
+// the same records as the manifest in the file name holds, telling from
+// the sizes and modification times of the files and opts.cache alone.
+// Files the cache doesn't know or knows with other digests than the
+// manifest count as changed.
+func unchangedSince(name string, src Source, root string, opts walkOptions) (bool, error) {
+	sums, algo, err := readManifest(name)
+	if err != nil {
+		return false, err
+	}
+	if algo != "" && algo != opts.algo {
+		return false, nil
+	}
+	unchanged := true
+	seen := 0
+	visitFiles(src, root, opts, func(path string, info os.FileInfo) {
+		seen++
+		if !unchanged {
+			return
+		}
+		want, ok := sums[path]
+		if !ok {
+			unchanged = false
+			return
+		}
+		sum, ok := opts.cache.lookup(path, info, opts.algo)
+		unchanged = ok && bytes.Equal(sum, want)
+	})
+	return unchanged && seen == len(sums), nil
+}
@@ -0,0 +1,64 @@
+package md5summer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// caseFolds finds paths of a run that differ only by case. They are
+// distinct files here, but collide once the tree is copied to a
+// case-insensitive filesystem, where verifying them depends on which of
+// them won. It is called with the lock of the checksums it belongs to
+// held. A nil *caseFolds finds no collisions.
+type caseFolds struct {
+	// first path seen for every folded path
+	first map[string]string
+	// every path of the folded paths seen more than once
+	groups map[string][]string
+}
+
+func newCaseFolds() *caseFolds {
+	return &caseFolds{first: make(map[string]string), groups: make(map[string][]string)}
+}
+
+func (f *caseFolds) add(path string) {
+	key := foldCase(path)
+	first, ok := f.first[key]
+	if !ok {
+		f.first[key] = path
+		return
+	}
+	if f.groups[key] == nil {
+		f.groups[key] = []string{first}
+	}
+	f.groups[key] = append(f.groups[key], path)
+}
+
+// collisions returns the groups of paths that differ only by case, each
+// sorted, ordered by their first path so the report is the same every
+// run.
+func (f *caseFolds) collisions() [][]string {
+	if f == nil {
+		return nil
+	}
+	var groups [][]string
+	for _, g := range f.groups {
+		sort.Strings(g)
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// foldCase maps s to the same string as every string it equals under
+// Unicode case folding, like strings.EqualFold compares them.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folded = min(folded, f)
+		}
+		return folded
+	}, s)
+}
@@ -0,0 +1,113 @@
+package md5summer
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChaos is the read error a ChaosSource injects.
+var ErrChaos = errors.New("read error injected by chaos")
+
+// ChaosOptions configure the faults a ChaosSource injects.
+type ChaosOptions struct {
+	// probability of a fault per read, between 0 and 1
+	P float64
+	// seeds the faults of every file, together with its path, so a run
+	// injects the same faults whatever order the files are read in
+	Seed uint64
+	// how long a slow read stalls, 100ms if 0
+	Delay time.Duration
+}
+
+// parseChaos parses -chaos, comma separated key=value pairs of p, seed
+// and delay.
+func parseChaos(spec string) (ChaosOptions, error) {
+	var c ChaosOptions
+	for _, kv := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return c, fmt.Errorf("expected key=value, got '%s'", kv)
+		}
+		var err error
+		switch key {
+		case "p":
+			if c.P, err = strconv.ParseFloat(value, 64); err == nil && (c.P < 0 || c.P > 1) {
+				err = errors.New("not between 0 and 1")
+			}
+		case "seed":
+			c.Seed, err = strconv.ParseUint(value, 10, 64)
+		case "delay":
+			c.Delay, err = time.ParseDuration(value)
+		default:
+			return c, fmt.Errorf("unknown key '%s', expected p, seed or delay", key)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid %s '%s': %v", key, value, err)
+		}
+	}
+	return c, nil
+}
+
+// ChaosSource returns a Source that reads the files of src, the local
+// filesystem if nil, with faults injected into the reads: they fail with
+// ErrChaos, stall or return data that changed while the file was read.
+// The files themselves are never touched. It is there to see that what
+// runs on top copes with failing disks, such as a program passing it as
+// Options.Source or VerifyOptions.Source; the -chaos flag uses it too.
+func ChaosSource(src Source, opts ChaosOptions) Source {
+	if src == nil {
+		src = localSource{}
+	}
+	if opts.Delay == 0 {
+		opts.Delay = 100 * time.Millisecond
+	}
+	return chaosSource{src, opts}
+}
+
+type chaosSource struct {
+	Source
+	chaos ChaosOptions
+}
+
+func (s chaosSource) Open(path string) (io.ReadCloser, error) {
+	f, err := s.Source.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	io.WriteString(h, path)
+	rnd := rand.New(rand.NewPCG(s.chaos.Seed, h.Sum64()))
+	return &chaosReader{f, s.chaos, rnd}, nil
+}
+
+// chaosReader injects faults into the reads of one file.
+type chaosReader struct {
+	io.ReadCloser
+	chaos ChaosOptions
+	rnd   *rand.Rand
+}
+
+func (r *chaosReader) Read(p []byte) (int, error) {
+	if r.rnd.Float64() >= r.chaos.P {
+		return r.ReadCloser.Read(p)
+	}
+	switch r.rnd.IntN(3) {
+	case 0:
+		return 0, ErrChaos
+	case 1:
+		time.Sleep(r.chaos.Delay)
+		return r.ReadCloser.Read(p)
+	}
+	// the file was written to while it was read
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		p[r.rnd.IntN(n)] ^= 0xff
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package md5summer
+
+import (
+	"context"
+	"io/fs"
+	"time"
+)
+
+// Options configure Checksum.
+type Options struct {
+	// hash algorithm, any the -algo flag takes alone, md5 if empty
+	Algo string
+	// number of files read at a time, numWorkers if 0
+	Workers int
+	// where the files are read from, the local filesystem if nil, so
+	// that trees only reachable through some other API can be walked
+	Source Source
+	// if set, the files are read from FS instead of Source, root and the
+	// paths below it being paths in FS that fs.ValidPath must accept
+	FS fs.FS
+	// if set, only the files it returns true for are checksummed. It is
+	// given their paths relative to root, slash separated.
+	Filter func(path string, info fs.FileInfo) bool
+	// if set, the directories below root it returns false for are
+	// skipped with everything in them, it is given paths like Filter
+	DirFilter func(path string, info fs.FileInfo) bool
+	// if set, called with the progress of the walk twice a second and
+	// once it is over
+	Progress func(Progress)
+	// with Progress, count the files and bytes before the walk so the
+	// totals are known
+	ScanFirst bool
+	// the clock Progress.Elapsed is measured by, time.Now if nil. It may
+	// be called from several goroutines at once.
+	Now func() time.Time
+	// if set, faults are injected into the reads of the files, as
+	// ChaosSource does, seeded by its Seed so that runs are repeatable
+	Chaos *ChaosOptions
+}
+
+// FileChecksum is the checksum of one file.
+type FileChecksum struct {
+	Path    string
+	Digest  []byte
+	Size    int64
+	ModTime time.Time
+}
+
+// Checksum calculates the checksums of the files below root, for programs
+// that want them rather than a manifest on their output. The checksums
+// are ordered by path, and paths are root joined with the path below it,
+// as Source.Walk gives them. The walk stops at the first file or
+// directory that can't be read, and once ctx is cancelled, with the error
+// of ctx.
+func Checksum(ctx context.Context, root string, opts Options) ([]FileChecksum, error) {
+	if opts.Algo == "" {
+		opts.Algo = "md5"
+	}
+	if _, err := newHash(opts.Algo); err != nil {
+		return nil, err
+	}
+	src := opts.Source
+	if opts.FS != nil {
+		src = fsSource{opts.FS}
+	}
+	if opts.Chaos != nil {
+		src = ChaosSource(src, *opts.Chaos)
+	}
+	st := newStatus()
+	if opts.Now != nil {
+		st.started = opts.Now()
+	}
+	less, _ := sortOrder("path", false)
+	sums, err := walkPath(ctx, root, &checksums{less: less}, st, walkOptions{
+		source:    src,
+		now:       opts.Now,
+		algo:      opts.Algo,
+		workers:   opts.Workers,
+		keepFile:  opts.Filter,
+		keepDir:   opts.DirFilter,
+		progress:  opts.Progress,
+		scanFirst: opts.ScanFirst,
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileChecksum, len(sums))
+	for ii, cs := range sums {
+		files[ii] = FileChecksum{cs.filepath, cs.sum, cs.size, cs.mtime}
+	}
+	return files, nil
+}
@@ -0,0 +1,269 @@
+package md5summer_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gpaul/md5summer"
+)
+
+// mapSource is a Source defined outside the package, over a MapFS.
+type mapSource struct {
+	fsys fstest.MapFS
+}
+
+func (s mapSource) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(s.fsys, root, func(path string, d fs.DirEntry, err error) error {
+		var info fs.FileInfo
+		if d != nil {
+			info, _ = d.Info()
+		}
+		return fn(path, info, err)
+	})
+}
+
+func (s mapSource) Open(path string) (io.ReadCloser, error) {
+	return s.fsys.Open(path)
+}
+
+func (s mapSource) Stat(path string) (os.FileInfo, error) {
+	return fs.Stat(s.fsys, path)
+}
+
+func TestChecksumSource(t *testing.T) {
+	src := mapSource{fstest.MapFS{
+		"tree/b":     {Data: []byte("bee")},
+		"tree/a/one": {Data: []byte("one")},
+		"tree/a/two": {Data: []byte("")},
+		"other":      {Data: []byte("not walked")},
+	}}
+	files, err := md5summer.Checksum(context.Background(), "tree", md5summer.Options{Algo: "sha256", Source: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		path string
+		data string
+	}{
+		{"tree/a/one", "one"},
+		{"tree/a/two", ""},
+		{"tree/b", "bee"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("got %d checksums, want %d: %v", len(files), len(want), files)
+	}
+	for ii, w := range want {
+		sum := sha256.Sum256([]byte(w.data))
+		f := files[ii]
+		if f.Path != w.path || string(f.Digest) != string(sum[:]) || f.Size != int64(len(w.data)) {
+			t.Errorf("checksum %d: got %s %x %d, want %s %x %d", ii, f.Path, f.Digest, f.Size, w.path, sum, len(w.data))
+		}
+	}
+}
+
+func TestChecksumFilter(t *testing.T) {
+	src := mapSource{fstest.MapFS{
+		"tree/keep.txt":        {Data: []byte("1")},
+		"tree/drop.bin":        {Data: []byte("2")},
+		"tree/sub/keep.txt":    {Data: []byte("3")},
+		"tree/skipped/in.txt":  {Data: []byte("4")},
+		"tree/skipped/deeper/": {Mode: fs.ModeDir},
+	}}
+	var dirs []string
+	opts := md5summer.Options{
+		Source: src,
+		Filter: func(path string, info fs.FileInfo) bool {
+			return filepath.Ext(path) == ".txt"
+		},
+		DirFilter: func(path string, info fs.FileInfo) bool {
+			dirs = append(dirs, path)
+			return path != "skipped"
+		},
+	}
+	files, err := md5summer.Checksum(context.Background(), "tree", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Path)
+	}
+	if want := []string{"tree/keep.txt", "tree/sub/keep.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the root isn't filtered and nothing below a skipped directory is
+	if want := []string{"skipped", "sub"}; !slices.Equal(dirs, want) {
+		t.Errorf("DirFilter was given %v, want %v", dirs, want)
+	}
+}
+
+// failingFS fails to read the files named in fail.
+type failingFS struct {
+	fstest.MapFS
+	fail string
+}
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	if name == f.fail {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("input/output error")}
+	}
+	return f.MapFS.Open(name)
+}
+
+func TestChecksumFS(t *testing.T) {
+	tree := fstest.MapFS{
+		"a":     {Data: []byte("12345")},
+		"dir/b": {Data: []byte("678")},
+	}
+	var last md5summer.Progress
+	calls := 0
+	opts := md5summer.Options{
+		FS:        failingFS{tree, ""},
+		ScanFirst: true,
+		Progress: func(p md5summer.Progress) {
+			last = p
+			calls++
+		},
+	}
+	files, err := md5summer.Checksum(context.Background(), ".", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != "a" || files[1].Path != "dir/b" {
+		t.Errorf("got %v", files)
+	}
+	want := md5summer.Progress{Files: 2, Bytes: 8, TotalFiles: 2, TotalBytes: 8, Elapsed: last.Elapsed, Done: true}
+	if calls == 0 || last != want {
+		t.Errorf("the last progress was %+v after %d calls, want %+v", last, calls, want)
+	}
+
+	opts = md5summer.Options{FS: failingFS{tree, "dir/b"}}
+	if _, err := md5summer.Checksum(context.Background(), ".", opts); err == nil || !strings.Contains(err.Error(), "input/output error") {
+		t.Errorf("a file that can't be read gave %v", err)
+	}
+}
+
+func TestChecksumUnknownAlgorithm(t *testing.T) {
+	if _, err := md5summer.Checksum(context.Background(), ".", md5summer.Options{Algo: "md4"}); err == nil {
+		t.Error("md4 was accepted")
+	}
+}
+
+func TestChaosSource(t *testing.T) {
+	tree := fstest.MapFS{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		tree["tree/"+name] = &fstest.MapFile{Data: []byte(strings.Repeat(name, 1000))}
+	}
+	src := md5summer.ChaosSource(mapSource{tree}, md5summer.ChaosOptions{P: 1, Seed: 7, Delay: time.Millisecond})
+	run := func() string {
+		_, err := md5summer.Checksum(context.Background(), "tree", md5summer.Options{Source: src, Workers: 1})
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+	// a fault on every read, so the walk can't come through, the same way
+	// every time
+	first := run()
+	if first == "" {
+		t.Fatal("every read faulted and the walk succeeded")
+	}
+	for range 3 {
+		if again := run(); again != first {
+			t.Errorf("the same seed failed with %q, then %q", first, again)
+		}
+	}
+
+	m := md5summer.NewManifest("md5")
+	for name, f := range tree {
+		sum := md5.Sum(f.Data)
+		m.Set(strings.TrimPrefix(name, "tree/"), sum[:])
+	}
+	report, err := md5summer.Verify(context.Background(), m, "tree", md5summer.VerifyOptions{Source: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("every read faulted and the tree verified")
+	}
+	for _, f := range report.Files {
+		if f.Verdict == md5summer.VerdictError && !errors.Is(f.Err, md5summer.ErrChaos) {
+			t.Errorf("%s failed with %v", f.Path, f.Err)
+		}
+	}
+}
+
+// stoppedClock returns a clock that reads start the first time and
+// start+elapsed ever after.
+func stoppedClock(elapsed time.Duration) func() time.Time {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var lk sync.Mutex
+	calls := 0
+	return func() time.Time {
+		lk.Lock()
+		defer lk.Unlock()
+		calls++
+		if calls == 1 {
+			return start
+		}
+		return start.Add(elapsed)
+	}
+}
+
+func TestInjectedClockAndChaos(t *testing.T) {
+	tree := fstest.MapFS{
+		"a":     {Data: []byte(strings.Repeat("a", 4096))},
+		"dir/b": {Data: []byte(strings.Repeat("b", 4096))},
+	}
+	var last md5summer.Progress
+	opts := md5summer.Options{FS: tree, Now: stoppedClock(3 * time.Second), Progress: func(p md5summer.Progress) { last = p }}
+	if _, err := md5summer.Checksum(context.Background(), ".", opts); err != nil {
+		t.Fatal(err)
+	}
+	if !last.Done || last.Elapsed != 3*time.Second {
+		t.Errorf("the walk ended with %+v, want 3s elapsed", last)
+	}
+
+	m := md5summer.NewManifest("md5")
+	for name, f := range tree {
+		sum := md5.Sum(f.Data)
+		m.Set(name, sum[:])
+	}
+	verify := func(opts md5summer.VerifyOptions) md5summer.Report {
+		report, err := md5summer.Verify(context.Background(), m, ".", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	last = md5summer.Progress{}
+	verify(md5summer.VerifyOptions{FS: tree, Now: stoppedClock(time.Minute), Progress: func(p md5summer.Progress) { last = p }})
+	want := md5summer.Progress{Files: 2, Bytes: 8192, TotalFiles: 2, Elapsed: time.Minute, Done: true}
+	if last != want {
+		t.Errorf("the verification ended with %+v, want %+v", last, want)
+	}
+
+	// the same seed injects the same faults
+	chaos := &md5summer.ChaosOptions{P: 0.5, Seed: 42, Delay: time.Millisecond}
+	first := verify(md5summer.VerifyOptions{FS: tree, Chaos: chaos})
+	for range 3 {
+		again := verify(md5summer.VerifyOptions{FS: tree, Chaos: chaos})
+		for ii := range first.Files {
+			if first.Files[ii].Verdict != again.Files[ii].Verdict {
+				t.Errorf("%s: %s, then %s", first.Files[ii].Path, first.Files[ii].Verdict, again.Files[ii].Verdict)
+			}
+		}
+	}
+}
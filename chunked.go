@@ -0,0 +1,145 @@
+package md5summer
+
+import (
+	"hash"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Chunked digests, set up by -chunk-size, let the chunks of a large file
+// be hashed in parallel. A file is split into chunks of a fixed size, the
+// last one shorter, and its digest is the digest of the digests of its
+// chunks, concatenated in order:
+//
+//	ALGO(ALGO(chunk 1) || ALGO(chunk 2) || ... || ALGO(chunk n))
+//
+// A file of a single chunk, or an empty one, has its plain digest. With
+// md5 and the part size of an S3 multipart upload this is the ETag S3
+// reports, without the "-n" suffix. The algorithm of chunked digests is
+// named ALGO/SIZE, the size in bytes, as in "md5/67108864".
+
+// chunkedAlgo splits the name of a chunked algorithm into the algorithm
+// of the chunks and their size.
+func chunkedAlgo(algo string) (string, int64, bool) {
+	base, size, ok := strings.Cut(algo, "/")
+	if !ok {
+		return "", 0, false
+	}
+	if _, ok := hashes[base]; !ok {
+		return "", 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	// only the canonical spelling names the algorithm
+	if err != nil || n <= 0 || strconv.FormatInt(n, 10) != size {
+		return "", 0, false
+	}
+	return base, n, true
+}
+
+// chunkedHash calculates a chunked digest of what is written to it, in
+// sequence.
+type chunkedHash struct {
+	newChunk func() hash.Hash
+	size     int64
+	// the chunk being written and its length so far
+	chunk hash.Hash
+	n     int64
+	// digests of the chunks before it
+	sums []byte
+}
+
+func newChunkedHash(newChunk func() hash.Hash, size int64) *chunkedHash {
+	return &chunkedHash{newChunk: newChunk, size: size, chunk: newChunk()}
+}
+
+func (h *chunkedHash) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// a chunk is only finished once more follows, so a file of
+		// exactly one chunk keeps its plain digest
+		if h.n == h.size {
+			h.sums = h.chunk.Sum(h.sums)
+			h.chunk.Reset()
+			h.n = 0
+		}
+		n := min(int64(len(p)), h.size-h.n)
+		h.chunk.Write(p[:n])
+		h.n += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (h *chunkedHash) Sum(b []byte) []byte {
+	if len(h.sums) == 0 {
+		return h.chunk.Sum(b)
+	}
+	outer := h.newChunk()
+	outer.Write(h.sums)
+	outer.Write(h.chunk.Sum(nil))
+	return outer.Sum(b)
+}
+
+func (h *chunkedHash) Reset() {
+	h.chunk.Reset()
+	h.n = 0
+	h.sums = nil
+}
+
+func (h *chunkedHash) Size() int      { return h.chunk.Size() }
+func (h *chunkedHash) BlockSize() int { return h.chunk.BlockSize() }
+
+// hashChunks calculates the chunked digest of the first size bytes of r,
+// hashing up to one chunk per CPU at a time. Every chunk is read through
+// wrap, which may count and interrupt the reads.
+func hashChunks(r io.ReaderAt, size, chunkSize int64, newChunk func() hash.Hash, wrap func(io.Reader) io.Reader) ([]byte, error) {
+	chunks := int((size + chunkSize - 1) / chunkSize)
+	sums := make([][]byte, chunks)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	done := make(chan struct{})
+	for range min(chunks, runtime.NumCPU()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				off := int64(i) * chunkSize
+				h := newChunk()
+				if _, err := io.Copy(h, wrap(io.NewSectionReader(r, off, min(chunkSize, size-off)))); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(done)
+					})
+					continue
+				}
+				sums[i] = h.Sum(nil)
+			}
+		}()
+	}
+feed:
+	for i := range chunks {
+		select {
+		case next <- i:
+		case <-done:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if chunks == 1 {
+		return sums[0], nil
+	}
+	outer := newChunk()
+	for _, sum := range sums {
+		outer.Write(sum)
+	}
+	return outer.Sum(nil), nil
+}
@@ -0,0 +1,8 @@
+// Command md5summer checksums directory trees, see the md5summer package.
+package main
+
+import "github.com/gpaul/md5summer"
+
+func main() {
+	md5summer.Main()
+}
@@ -0,0 +1,222 @@
+package md5summer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// verdictExtra is the outcome for a file that only the second tree of a
+// comparison has.
+const verdictExtra = "EXTRA"
+
+// runCompare implements the compare subcommand: it checksums two trees,
+// each of which may be local or remote, and reports every file by its
+// path relative to the roots as OK, FAILED if the digests differ,
+// MISSING if only the first tree has it or EXTRA if only the second does.
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	algo := fs.String("algo", "md5", "hash algorithm: md5, sha1, sha256, sha512, blake2b, xxhash or crc32c")
+	noTrustRemote := fs.Bool("no-trust-remote", false, "read every file of a remote tree instead of using the digests its provider reports")
+	asJSON := fs.Bool("json", false, "print the outcome as a JSON array instead of a line per file")
+	failFast := fs.Bool("fail-fast", false, "stop at the first file that differs, print it and exit 1")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer compare [-algo name] [-no-trust-remote] [-json] [-fail-fast] A B")
+		fmt.Fprintln(fs.Output(), "A and B are local directories, file:// URLs or any remote -dir")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	if _, err := newHash(*algo); err != nil {
+		panic(fmt.Errorf("invalid -algo: %v", err))
+	}
+
+	var stop func(verification) bool
+	if *failFast {
+		stop = differs
+	}
+	results := compareTrees(fs.Arg(0), fs.Arg(1), walkOptions{algo: *algo}, *noTrustRemote, stop)
+	if *asJSON {
+		reportVerificationsJSON(results)
+	}
+	reportVerifications(results)
+}
+
+// differs reports whether v is anything but OK, it stops -fail-fast runs
+// that have no policy.
+func differs(v verification) bool {
+	return v.verdict != verdictOK
+}
+
+// compareTrees checksums the trees a and b concurrently, and returns the
+// outcome for every file by its path relative to the roots. Local trees
+// are walked with opts, which also sets the hash algorithm. If stop is
+// set, the comparison ends at the first outcome it returns true for, the
+// only one returned then: a file both trees have stops the walks as soon
+// as it is read on both sides, one only a tree has once both are walked.
+func compareTrees(a, b string, opts walkOptions, noTrustRemote bool, stop func(verification) bool) []verification {
+	roots := [2]string{a, b}
+	var trees [2]map[string][]byte
+	var errs [2]error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// with stop, the files of both trees as they are read
+	var lk sync.Mutex
+	var read [2]map[string][]byte
+	var first *verification
+	found := func(ii int, rel string, sum []byte) {
+		lk.Lock()
+		defer lk.Unlock()
+		if first != nil {
+			return
+		}
+		if read[ii] == nil {
+			read[ii] = make(map[string][]byte)
+		}
+		read[ii][rel] = sum
+		other, ok := read[1-ii][rel]
+		if !ok || bytes.Equal(sum, other) {
+			return
+		}
+		v := verification{path: rel, verdict: verdictFailed, expected: read[0][rel], actual: read[1][rel]}
+		if stop(v) {
+			first = &v
+			cancel()
+		}
+	}
+	var wg sync.WaitGroup
+	for ii := range trees {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var each func(rel string, sum []byte)
+			if stop != nil {
+				each = func(rel string, sum []byte) { found(ii, rel, sum) }
+			}
+			trees[ii], errs[ii] = checksumTree(ctx, roots[ii], opts, noTrustRemote, each)
+		}()
+	}
+	wg.Wait()
+	if first != nil {
+		log.Printf("stopped at the first difference")
+		return []verification{*first}
+	}
+	for ii, err := range errs {
+		if err != nil {
+			panic(fmt.Errorf("could not checksum '%s': %v", roots[ii], err))
+		}
+	}
+
+	v := newVerifier()
+	for rel, sum := range trees[0] {
+		other, ok := trees[1][rel]
+		switch {
+		case !ok:
+			v.record(verification{path: rel, verdict: verdictMissing, expected: sum})
+		case !bytes.Equal(sum, other):
+			v.record(verification{path: rel, verdict: verdictFailed, expected: sum, actual: other})
+		default:
+			v.record(verification{path: rel, verdict: verdictOK, expected: sum, actual: other})
+		}
+	}
+	for rel, sum := range trees[1] {
+		if _, ok := trees[0][rel]; !ok {
+			v.record(verification{path: rel, verdict: verdictExtra, actual: sum})
+		}
+	}
+	results := v.wait()
+	if stop != nil {
+		// only files one of the trees lacks are left to stop at
+		for _, res := range results {
+			if stop(res) {
+				log.Printf("stopped at the first difference")
+				return []verification{res}
+			}
+		}
+	}
+	return results
+}
+
+// checksumTree checksums the tree at dir, a local path, a file:// URL or
+// a remote -dir, until ctx is cancelled, and returns the digests by
+// slash-separated path relative to dir. Local trees are read from
+// opts.source if set. If each is set, it is called with every file as
+// it is read.
+func checksumTree(ctx context.Context, dir string, opts walkOptions, noTrustRemote bool, each func(rel string, sum []byte)) (map[string][]byte, error) {
+	remote, ok, err := remoteSource(dir)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if noTrustRemote {
+			remote = untrustedSource{remote}
+		}
+		opts.source = remote
+		if !strings.HasSuffix(dir, "://") {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+	} else {
+		if dir, err = filepath.Abs(strings.TrimPrefix(dir, "file://")); err != nil {
+			return nil, err
+		}
+		if opts.source == nil {
+			opts.source = localSource{}
+		}
+	}
+	if info, err := opts.source.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory")
+	}
+
+	tree := make(map[string][]byte)
+	acc := &checksums{stream: func(cs checksum) {
+		rel := strings.TrimPrefix(cs.filepath, dir)
+		rel = filepath.ToSlash(strings.TrimLeft(rel, "/"+string(filepath.Separator)))
+		tree[rel] = cs.sum
+		if each != nil {
+			each(rel, cs.sum)
+		}
+	}}
+	if _, err := walkPath(ctx, dir, acc, newStatus(), opts); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// reportVerificationsJSON prints the outcome of every verification to
+// reports as a JSON array and the totals to stderr, and exits with the
+// matching status.
+func reportVerificationsJSON(results []verification) {
+	type jsonVerification struct {
+		Path     string `json:"path"`
+		Verdict  string `json:"verdict"`
+		Expected string `json:"expected,omitempty"`
+		Actual   string `json:"actual,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}
+	out := make([]jsonVerification, len(results))
+	for ii, v := range results {
+		out[ii] = jsonVerification{Path: v.path, Verdict: v.verdict, Expected: hex.EncodeToString(v.expected), Actual: hex.EncodeToString(v.actual)}
+		if v.err != nil {
+			out[ii].Error = v.err.Error()
+		}
+	}
+	enc := json.NewEncoder(reports)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		panic(fmt.Errorf("could not write results: %v", err))
+	}
+	exitVerified(results)
+}
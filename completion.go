@@ -0,0 +1,223 @@
+package md5summer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// subcommands lists the verbs understood in place of the default
+// checksumming run, with a one-line description each.
+var subcommands = []struct {
+	name, usage string
+}{
+	{"version", "print version and build information"},
+	{"completion", "print a shell completion script for bash, zsh or fish"},
+	{"man", "print a man page"},
+	{"estimate", "predict the run time and memory use of a run"},
+	{"copy", "copy a tree, verifying every copied file, and print a manifest of the copy"},
+	{"move", "move a tree, removing each source file only once its copy is verified"},
+	{"compare", "compare two trees, local or remote, file by file"},
+	{"serve-files", "serve a tree over HTTP with the digest of every file in its headers"},
+	{"fetch", "download a URL, keeping the file only if it matches the digests sent or expected"},
+	{"fmt", "sort, de-duplicate and normalize manifests into one canonical manifest"},
+	{"monitor", "checksum every file below a directory as soon as it is written, Linux only"},
+	{"keygen", "print a new random key for -encrypt or -sign-key"},
+	{"decrypt", "decrypt a manifest written with -encrypt"},
+	{"pubkey", "print the public key of a -sign-key key"},
+	{"verify-disc", "verify a mounted disc against the manifest burned at its root"},
+}
+
+// hiddenFlags are left out of the usage, completions and man page, they
+// are meant for testing md5summer and what runs it.
+var hiddenFlags = map[string]bool{"chaos": true}
+
+// visitFlags calls fn for every top-level flag that isn't hidden, in
+// lexicographical order.
+func visitFlags(fn func(*flag.Flag)) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			fn(f)
+		}
+	})
+}
+
+// usage prints the usage like the default flag.Usage does, without the
+// hidden flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(out)
+	visitFlags(func(f *flag.Flag) { visible.Var(f.Value, f.Name, f.Usage) })
+	visible.PrintDefaults()
+}
+
+// flagNames returns the names of all top-level flags, in lexicographical
+// order.
+func flagNames() []string {
+	var names []string
+	visitFlags(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+// isBoolFlag reports whether f is a switch that takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runCompletion implements the completion subcommand.
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		panic(fmt.Errorf("usage: md5summer completion bash|zsh|fish"))
+	}
+	var err error
+	switch args[0] {
+	case "bash":
+		err = writeBashCompletion(os.Stdout)
+	case "zsh":
+		err = writeZshCompletion(os.Stdout)
+	case "fish":
+		err = writeFishCompletion(os.Stdout)
+	default:
+		err = fmt.Errorf("unsupported shell '%s', expected bash, zsh or fish", args[0])
+	}
+	if err != nil {
+		panic(fmt.Errorf("could not write completion: %v", err))
+	}
+}
+
+func writeBashCompletion(w io.Writer) error {
+	var verbs, opts []string
+	for _, sub := range subcommands {
+		verbs = append(verbs, sub.name)
+	}
+	for _, name := range flagNames() {
+		opts = append(opts, "-"+name)
+	}
+	_, err := fmt.Fprintf(w, `_md5summer() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	-dir)
+		COMPREPLY=($(compgen -d -- "$cur"))
+		return
+		;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+		;;
+	esac
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s %s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	fi
+}
+complete -F _md5summer md5summer
+`, strings.Join(verbs, " "), strings.Join(opts, " "), strings.Join(opts, " "))
+	return err
+}
+
+func writeZshCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("#compdef md5summer\n\n_md5summer() {\n\tlocal -a verbs\n\tverbs=(\n")
+	for _, sub := range subcommands {
+		fmt.Fprintf(&b, "\t\t'%s:%s'\n", sub.name, zshEscape(sub.usage))
+	}
+	b.WriteString("\t)\n\t_arguments \\\n")
+	visitFlags(func(f *flag.Flag) {
+		action := ":value:"
+		switch {
+		case isBoolFlag(f):
+			action = ""
+		case f.Name == "dir":
+			action = ":directory:_files -/"
+		}
+		fmt.Fprintf(&b, "\t\t'-%s[%s]%s' \\\n", f.Name, zshEscape(f.Usage), action)
+	})
+	b.WriteString("\t\t'1: :{_describe command verbs}'\n}\n\n_md5summer \"$@\"\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zshEscape makes s safe inside a single-quoted _arguments spec.
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	for _, sub := range subcommands {
+		fmt.Fprintf(&b, "complete -c md5summer -n __fish_use_subcommand -f -a %s -d %s\n", sub.name, fishQuote(sub.usage))
+	}
+	b.WriteString("complete -c md5summer -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'\n")
+	visitFlags(func(f *flag.Flag) {
+		extra := ""
+		switch {
+		case f.Name == "dir":
+			extra = " -x -a '(__fish_complete_directories)'"
+		case !isBoolFlag(f):
+			extra = " -r"
+		}
+		fmt.Fprintf(&b, "complete -c md5summer -o %s%s -d %s\n", f.Name, extra, fishQuote(f.Usage))
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// runMan implements the man subcommand, writing a roff man page to stdout.
+func runMan(args []string) {
+	if err := writeManPage(os.Stdout); err != nil {
+		panic(fmt.Errorf("could not write man page: %v", err))
+	}
+}
+
+func writeManPage(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, ".TH MD5SUMMER 1 %q %q\n", time.Now().Format("2006-01-02"), "md5summer "+version)
+	b.WriteString(".SH NAME\nmd5summer \\- calculate checksums of every file in a directory tree\n")
+	b.WriteString(".SH SYNOPSIS\n.B md5summer\n[\\fIoptions\\fR]\n.br\n.B md5summer\n\\fIcommand\\fR [\\fIargs\\fR]\n")
+	b.WriteString(".SH DESCRIPTION\nWalks a directory tree, calculates a checksum of every regular file\nconcurrently and writes one record per file to standard output.\nDiagnostics are written to standard error.\n")
+	b.WriteString(".SH OPTIONS\n")
+	visitFlags(func(f *flag.Flag) {
+		fmt.Fprintf(&b, ".TP\n.B \\-%s", roffEscape(f.Name))
+		if !isBoolFlag(f) {
+			name, _ := flag.UnquoteUsage(f)
+			if name == "" {
+				name = "value"
+			}
+			fmt.Fprintf(&b, " \\fI%s\\fR", roffEscape(name))
+		}
+		b.WriteString("\n" + roffEscape(f.Usage))
+		if f.DefValue != "" && !isBoolFlag(f) {
+			fmt.Fprintf(&b, " (default: %s)", roffEscape(f.DefValue))
+		}
+		b.WriteString("\n")
+	})
+	b.WriteString(".SH COMMANDS\n")
+	for _, sub := range subcommands {
+		fmt.Fprintf(&b, ".TP\n.B %s\n%s\n", sub.name, roffEscape(sub.usage))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// roffEscape escapes backslashes and hyphens, and keeps lines from
+// starting with a control character.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
@@ -0,0 +1,166 @@
+package md5summer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCopy implements the copy subcommand: it copies every file below src
+// to the same relative path below dst, checksumming the source while it
+// is copied and the destination after it was written, and prints a
+// manifest of the verified destination files. Where fadvise is supported
+// the destination is read back from the disk, proving what the disk
+// returns; elsewhere the read-back may be served from the page cache and
+// only proves the copy made it into memory intact.
+func runCopy(args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer copy SRC DST")
+		fmt.Fprintln(fs.Output(), "every copy is flushed and read back from the disk before it is kept, from the page cache where the platform can't drop it")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	src, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(0), err))
+	}
+	dst, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(1), err))
+	}
+	if rel, err := filepath.Rel(src, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		panic(fmt.Errorf("cannot copy '%s' into itself", src))
+	}
+
+	opts := walkOptions{process: func(path string, info os.FileInfo, c ctrl) {
+		copyFile(src, dst, path, info, c)
+	}}
+	byPath, _ := sortOrder("path", false)
+	checksums, err := walkPath(context.Background(), src, &checksums{less: byPath}, newStatus(), opts)
+	if err != nil {
+		panic(fmt.Errorf("could not copy '%s' to '%s': %v", src, dst, err))
+	}
+	for _, cs := range checksums {
+		fmt.Println(cs.String())
+	}
+}
+
+// copyFile copies path, which is below srcRoot, to the same relative path
+// below dstRoot and records the checksum of the verified copy.
+func copyFile(srcRoot, dstRoot, path string, info os.FileInfo, c ctrl) {
+	defer c.wg.Done()
+	defer c.throttle.ready()
+
+	rel, err := filepath.Rel(srcRoot, path)
+	if err != nil {
+		notifyErr(c, err)
+		return
+	}
+	target := filepath.Join(dstRoot, rel)
+	sum, err := copyVerified(path, target, info, c)
+	if err != nil {
+		notifyErr(c, err)
+		return
+	}
+	c.acc.add(checksum{target, sum, info.Size(), info.ModTime(), "md5", nil})
+}
+
+// copyVerified copies the file at path to target, creating missing
+// directories. The copy is written to a temporary file next to target,
+// flushed, read back past the page cache where fadvise is supported and
+// compared with the source, and only renamed into place once it matches. Existing files are never overwritten. It returns the
+// checksum of the copy.
+func copyVerified(path, target string, info os.FileInfo, c ctrl) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return nil, fmt.Errorf("'%s' already exists", target)
+	}
+	// a temporary file left behind by an interrupted run is ours to remove
+	tmp := target + ".md5summer-tmp"
+	os.Remove(tmp)
+
+	srcSum, err := copyContents(path, tmp, info, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the copy back, this is what actually proves it is good
+	dstSum, err := sumFile(tmp)
+	if err == nil && !bytes.Equal(dstSum, srcSum) {
+		err = fmt.Errorf("copy of '%s' does not match the source", path)
+	}
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return srcSum, nil
+}
+
+// sumFile returns the md5 checksum of the file at path. Its cached pages
+// are dropped first, so the read goes to the disk where fadvise is
+// supported; pages not yet written back stay cached, flush them first.
+func sumFile(path string) ([]byte, error) {
+	file, err := openRead(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dropCached(file.File)
+	adviseSequential(file.File)
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// copyContents copies the file at src to the new file dst, flushing it to
+// disk and carrying over its permissions and modification time. It
+// returns the checksum of the bytes read from src.
+func copyContents(src, dst string, info os.FileInfo, c ctrl) ([]byte, error) {
+	in, err := openRead(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	adviseSequential(in.File)
+
+	out, err := openFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	af := c.status.start(src, info.Size())
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), progressReader{in, af, c.status})
+	c.status.finish(af, err == nil)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return nil, err
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,211 @@
+package md5summer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted manifests start with cryptMagic and a random salt, from which
+// and the key in the key file the AES-256-GCM key of the manifest is
+// derived. The manifest follows in chunks of cryptChunkSize bytes, each
+// sealed separately with a nonce made of the chunk's sequence number and
+// a flag marking the last chunk, so chunks can neither be reordered nor
+// dropped from the end without detection.
+const (
+	cryptMagic     = "md5summer-aes256gcm-v1\n"
+	cryptSaltSize  = 16
+	cryptChunkSize = 64 * 1024
+)
+
+// loadKey reads a 256-bit key from a file holding it as 64 hex digits,
+// as base64, or as 32 raw bytes.
+func loadKey(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("'%s' does not hold a 256-bit key in hex, base64 or raw form", name)
+}
+
+// parseEncryptFlag reads the key named by the value of -encrypt, which
+// has the form aes:keyfile.
+func parseEncryptFlag(value string) ([]byte, error) {
+	scheme, keyfile, ok := strings.Cut(value, ":")
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("expected aes:keyfile, got '%s'", value)
+	case scheme == "age":
+		return nil, errors.New("age encryption needs ChaCha20-Poly1305 from golang.org/x/crypto and is not supported, use aes:keyfile")
+	case scheme != "aes":
+		return nil, fmt.Errorf("unknown encryption scheme '%s', expected aes", scheme)
+	}
+	return loadKey(keyfile)
+}
+
+func manifestCipher(key, salt []byte) (cipher.AEAD, error) {
+	derived, err := hkdf.Key(sha256.New, key, salt, "md5summer manifest", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it. Close must be called
+// to write the final chunk, without it the output doesn't decrypt.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	chunk uint64
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	salt := make([]byte, cryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := manifestCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, cryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	ew.buf = append(ew.buf, p...)
+	// keep a full chunk buffered, the last one is only known on Close
+	for len(ew.buf) > cryptChunkSize {
+		if err := ew.seal(ew.buf[:cryptChunkSize], false); err != nil {
+			return 0, err
+		}
+		ew.buf = ew.buf[cryptChunkSize:]
+	}
+	return n, nil
+}
+
+func (ew *encryptWriter) seal(plain []byte, last bool) error {
+	_, err := ew.w.Write(ew.aead.Seal(nil, chunkNonce(ew.chunk, last), plain, nil))
+	ew.chunk++
+	return err
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(ew.buf, true)
+}
+
+// decrypt reverses encryptWriter, writing the plain manifest read from r
+// to w.
+func decrypt(w io.Writer, r io.Reader, key []byte) error {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(cryptMagic)+cryptSaltSize)
+	if _, err := io.ReadFull(br, head); err != nil || !bytes.HasPrefix(head, []byte(cryptMagic)) {
+		return errors.New("not an encrypted manifest")
+	}
+	aead, err := manifestCipher(key, head[len(cryptMagic):])
+	if err != nil {
+		return err
+	}
+	sealed := make([]byte, cryptChunkSize+aead.Overhead())
+	for chunk := uint64(0); ; chunk++ {
+		n, err := io.ReadFull(br, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		// a full chunk is the last one too if nothing follows it
+		last := n < len(sealed)
+		if _, err := br.Peek(1); err != nil {
+			last = true
+		}
+		plain, err := aead.Open(nil, chunkNonce(chunk, last), sealed[:n], nil)
+		if err != nil {
+			return errors.New("manifest is corrupt, truncated or encrypted with another key")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// runDecrypt implements the decrypt subcommand.
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyfile := fs.String("key", "", "file holding the key the manifest was encrypted with")
+	fs.Parse(args)
+	if *keyfile == "" || fs.NArg() > 1 {
+		fmt.Fprintln(fs.Output(), "usage: md5summer decrypt -key keyfile [manifest]")
+		os.Exit(exitError)
+	}
+	key, err := loadKey(*keyfile)
+	if err != nil {
+		panic(err)
+	}
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 1 {
+		f, err := openRead(fs.Arg(0))
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := decrypt(os.Stdout, in, key); err != nil {
+		panic(fmt.Errorf("cannot decrypt: %v", err))
+	}
+}
+
+// runKeygen implements the keygen subcommand, printing a new random key
+// in hex.
+func runKeygen(args []string) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	fmt.Println(hex.EncodeToString(key))
+}
@@ -0,0 +1,90 @@
+package md5summer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// encrypted returns plain encrypted with key, and the offset its sealed
+// chunks start at.
+func encrypted(t *testing.T, plain, key []byte) ([]byte, int) {
+	t.Helper()
+	var b bytes.Buffer
+	ew, err := newEncryptWriter(&b, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in uneven writes, so chunks don't follow them
+	for len(plain) > 0 {
+		n := min(len(plain), 1000)
+		if _, err := ew.Write(plain[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[n:]
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), len(cryptMagic) + cryptSaltSize
+}
+
+func TestDecryptRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	for _, size := range []int{0, 10, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3*cryptChunkSize + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		data, _ := encrypted(t, plain, key)
+		var got bytes.Buffer
+		if err := decrypt(&got, bytes.NewReader(data), key); err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+		} else if !bytes.Equal(got.Bytes(), plain) {
+			t.Errorf("%d bytes: decrypted to %d other bytes", size, got.Len())
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 2*cryptChunkSize+100)
+	rand.Read(plain)
+	data, start := encrypted(t, plain, key)
+	sealed := cryptChunkSize + 16
+	chunk := func(n int) []byte {
+		return data[start+n*sealed : min(start+(n+1)*sealed, len(data))]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	otherKey := make([]byte, 32)
+	rand.Read(otherKey)
+	flipped := bytes.Clone(data)
+	flipped[start+100] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+		key  []byte
+	}{
+		{"last chunk dropped", data[:start+2*sealed], key},
+		{"last two chunks dropped", data[:start+sealed], key},
+		{"cut in the last chunk", data[:len(data)-1], key},
+		{"cut in a chunk", data[:start+sealed+10], key},
+		{"salt only", data[:start], key},
+		{"cut in the salt", data[:start-1], key},
+		{"chunks swapped", join(data[:start], chunk(1), chunk(0), chunk(2)), key},
+		{"chunk repeated", join(data[:start], chunk(0), chunk(0), chunk(1), chunk(2)), key},
+		{"chunk appended", join(data, chunk(2)), key},
+		{"bit flipped", flipped, key},
+		{"other key", data, otherKey},
+		{"not encrypted", plain, key},
+	}
+	for _, test := range tests {
+		if err := decrypt(io.Discard, bytes.NewReader(test.data), test.key); err == nil {
+			t.Errorf("%s: decrypted", test.name)
+		}
+	}
+}
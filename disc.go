@@ -0,0 +1,167 @@
+package md5summer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runVerifyDisc implements the verify-disc subcommand: it verifies a
+// mounted disc, such as an archival Blu-ray, against the manifest burned
+// along with the data, found at the root of the disc. Files on the disc
+// that the manifest doesn't list are EXTRA.
+func runVerifyDisc(args []string) {
+	fs := flag.NewFlagSet("verify-disc", flag.ExitOnError)
+	manifest := fs.String("manifest", "", "the manifest on the disc, relative to its root; by default the one md5summer manifest or checksum file such as SHA256SUMS at the root")
+	// optical drives seek slowly, reading files side by side is slower
+	workers := fs.Int("workers", 1, "number of files to read at a time")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer verify-disc [-manifest NAME] [-workers N] MOUNTPOINT")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	if *workers < 1 {
+		panic(fmt.Errorf("-workers must be at least 1"))
+	}
+	mount, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(0), err))
+	}
+
+	name := *manifest
+	if name == "" {
+		if name, err = findDiscManifest(mount); err != nil {
+			panic(fmt.Errorf("cannot find the manifest of '%s': %v", mount, err))
+		}
+		log.Printf("verifying against %s", name)
+	} else if !filepath.IsAbs(name) {
+		name = filepath.Join(mount, name)
+	}
+	sums, algo, err := readManifest(name)
+	if err != nil {
+		panic(fmt.Errorf("cannot read manifest '%s': %v", name, err))
+	}
+	root, err := manifestRoot(name)
+	if err != nil {
+		panic(fmt.Errorf("cannot read manifest '%s': %v", name, err))
+	}
+
+	// the manifest may list the paths the files had where it was written
+	listed := make(map[string][]byte, len(sums))
+	for path, sum := range sums {
+		if filepath.IsAbs(path) {
+			if root == "" {
+				panic(fmt.Errorf("'%s' lists %s but gives no root to find it below, write manifests for discs with -header", name, path))
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil || !filepath.IsLocal(rel) {
+				panic(fmt.Errorf("'%s' lists %s, which is not below the root it gives", name, path))
+			}
+			path = rel
+		}
+		path = filepath.Join(mount, path)
+		// a manifest written into the tree can't have its own digest
+		if path != name {
+			listed[path] = sum
+		}
+	}
+	paths := make([]string, 0, len(listed))
+	for path := range listed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	v := newContextVerifier(context.Background(), *workers)
+	for _, path := range paths {
+		v.check(path, algo, listed[path])
+	}
+	err = filepath.Walk(mount, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			v.record(verification{path: path, verdict: verdictError, err: err})
+			return nil
+		}
+		if !info.Mode().IsRegular() || path == name {
+			return nil
+		}
+		if _, ok := listed[path]; !ok {
+			v.record(verification{path: path, verdict: verdictExtra})
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("could not walk '%s': %v", mount, err))
+	}
+	reportVerifications(v.wait())
+}
+
+// findDiscManifest returns the manifest at the root of the disc mounted
+// at mount: the one checksum file named like MD5SUMS or data.sha256, or a
+// manifest written by md5summer with -header.
+func findDiscManifest(mount string) (string, error) {
+	entries, err := os.ReadDir(mount)
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(mount, e.Name())
+		if _, ok := sidecarAlgorithm(e.Name()); ok || hasManifestHeader(path) {
+			found = append(found, path)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", errors.New("no manifest at its root, name it with -manifest")
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("several manifests at its root, name one with -manifest: %s", strings.Join(found, ", "))
+}
+
+// hasManifestHeader tells whether the file at path starts like a
+// manifest written by md5summer with -header.
+func hasManifestHeader(path string) bool {
+	f, err := openRead(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.HasPrefix(line, headerPrefix+"md5summer ")
+}
+
+// manifestRoot returns the root given in the header of a manifest, or
+// "" if it has none.
+func manifestRoot(name string) (string, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if !strings.HasPrefix(line, headerPrefix) {
+			// the header is over
+			break
+		}
+		if root, ok := strings.CutPrefix(line, headerPrefix+"root "); ok {
+			return root, nil
+		}
+	}
+	return "", scanner.Err()
+}
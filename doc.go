@@ -0,0 +1,8 @@
+// Package md5summer checksums directory trees and verifies them against
+// manifests of their checksums.
+//
+// The md5summer command, in cmd/md5summer, is built on it. Programs can
+// checksum a tree, local or from any Source, with Checksum, load, merge
+// and compare manifests with Manifest and verify a tree against one with
+// Verify.
+package md5summer
@@ -0,0 +1,101 @@
+package md5summer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// rereadDigest reads the file at path once more and returns its digest.
+// The cached pages of the file are dropped first, so the read goes to
+// the disk where fadvise is supported.
+func rereadDigest(path string, af *activeFile, c ctrl) ([]byte, error) {
+	file, err := c.source.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if f, ok := file.(*auditedFile); ok {
+		dropCached(f.File)
+	}
+	hash, err := newHash(c.algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hash, progressReader{contextReader{c.ctx, file}, af, c.status}); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// checkReread reads the file at path a second time for -double-read and
+// compares its digest with sum, the one of the first read. Differing
+// digests point at flaky memory, controllers or disks.
+func checkReread(path string, sum []byte, af *activeFile, c ctrl) error {
+	again, err := rereadDigest(path, af, c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(again, sum) {
+		return fmt.Errorf("two reads gave different digests, %s and %s", base64.StdEncoding.EncodeToString(sum), base64.StdEncoding.EncodeToString(again))
+	}
+	return nil
+}
+
+// errNoMajority fails a file of which no digest was read by a majority
+// of the reads of -best-of.
+var errNoMajority = errors.New("no digest was read by a majority")
+
+// majorityDigest reads the file at path until it was read c.bestOf
+// times, sum being the digest of the first read, and returns the digest
+// most reads agree on, if more than half of them do. Reads that fail
+// count against every digest. If the reads disagree, what each of them
+// gave is added to c.disagreements.
+func majorityDigest(path string, sum []byte, af *activeFile, c ctrl) ([]byte, error) {
+	votes := map[string]int{string(sum): 1}
+	failed := 0
+	for range c.bestOf - 1 {
+		again, err := rereadDigest(path, af, c)
+		if err != nil {
+			if c.ctx.Err() != nil || err == errSkipped {
+				return nil, err
+			}
+			failed++
+			continue
+		}
+		votes[string(again)]++
+	}
+	digests := make([]string, 0, len(votes))
+	for d := range votes {
+		digests = append(digests, d)
+	}
+	// most votes first, ties broken by digest so the report is stable
+	sort.Slice(digests, func(i, j int) bool {
+		if votes[digests[i]] != votes[digests[j]] {
+			return votes[digests[i]] > votes[digests[j]]
+		}
+		return digests[i] < digests[j]
+	})
+	var err error
+	if votes[digests[0]]*2 <= c.bestOf {
+		err = errNoMajority
+	}
+	if len(digests) > 1 || failed > 0 {
+		var details []string
+		for _, d := range digests {
+			details = append(details, fmt.Sprintf("%d gave %s", votes[d], base64.StdEncoding.EncodeToString([]byte(d))))
+		}
+		if failed > 0 {
+			details = append(details, fmt.Sprintf("%d failed", failed))
+		}
+		c.disagreements.add(path, fmt.Errorf("of %d reads %s", c.bestOf, strings.Join(details, ", ")))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(digests[0]), nil
+}
@@ -0,0 +1,398 @@
+package md5summer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints of the cloud drive APIs, variables so they can be pointed
+// at a test server.
+var (
+	googleDriveAPI = "https://www.googleapis.com/drive/v3"
+	oneDriveAPI    = "https://graph.microsoft.com/v1.0"
+)
+
+// driveEntry is a file or folder of a cloud drive. It is the
+// os.FileInfo of the drive's Source.
+type driveEntry struct {
+	id       string
+	name     string
+	dir      bool
+	size     int64
+	modified time.Time
+	// content digests reported by the provider, by algorithm
+	hashes map[string][]byte
+}
+
+func (e driveEntry) Name() string { return e.name }
+func (e driveEntry) Size() int64  { return e.size }
+func (e driveEntry) Mode() os.FileMode {
+	if e.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (e driveEntry) ModTime() time.Time { return e.modified }
+func (e driveEntry) IsDir() bool        { return e.dir }
+func (e driveEntry) Sys() any           { return nil }
+
+// driveAPI is what driveSource needs of a cloud drive provider.
+type driveAPI interface {
+	root() (driveEntry, error)
+	list(dir driveEntry) ([]driveEntry, error)
+	open(file driveEntry) (io.ReadCloser, error)
+}
+
+// driveSource is a Source for a cloud drive, whose paths are the scheme
+// followed by the names of the folders leading to a file, like
+// drive://Photos/2020/beach.jpg. Drives address files by ID, the IDs of
+// the paths seen are remembered.
+type driveSource struct {
+	scheme  string
+	api     driveAPI
+	lk      sync.Mutex
+	entries map[string]driveEntry
+}
+
+func newDriveSource(scheme string, api driveAPI) *driveSource {
+	return &driveSource{scheme: scheme, api: api, entries: make(map[string]driveEntry)}
+}
+
+// child returns the path of the entry called name in the folder at dir.
+func (s *driveSource) child(dir, name string) string {
+	if dir == s.scheme+"://" {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+func (s *driveSource) remember(name string, e driveEntry) {
+	s.lk.Lock()
+	s.entries[name] = e
+	s.lk.Unlock()
+}
+
+func (s *driveSource) Stat(name string) (os.FileInfo, error) {
+	return s.stat(name)
+}
+
+// stat finds the entry at name by listing the folders leading to it,
+// unless it was seen before.
+func (s *driveSource) stat(name string) (driveEntry, error) {
+	s.lk.Lock()
+	e, ok := s.entries[name]
+	s.lk.Unlock()
+	if ok {
+		return e, nil
+	}
+	rel, ok := strings.CutPrefix(name, s.scheme+"://")
+	if !ok {
+		return driveEntry{}, fmt.Errorf("'%s' is not a %s:// path", name, s.scheme)
+	}
+	e, err := s.api.root()
+	if err != nil {
+		return driveEntry{}, err
+	}
+	current := s.scheme + "://"
+	for _, part := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		entries, err := s.api.list(e)
+		if err != nil {
+			return driveEntry{}, err
+		}
+		found := false
+		for _, entry := range entries {
+			if entry.name == part {
+				e, found = entry, true
+				break
+			}
+		}
+		current = s.child(current, part)
+		if !found {
+			return driveEntry{}, &os.PathError{Op: "stat", Path: current, Err: os.ErrNotExist}
+		}
+	}
+	s.remember(name, e)
+	return e, nil
+}
+
+func (s *driveSource) Open(name string) (io.ReadCloser, error) {
+	e, err := s.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.api.open(e)
+}
+
+// Hash returns the digest the provider reports for the file at name, if
+// it reports one for algo.
+func (s *driveSource) Hash(name, algo string) ([]byte, bool) {
+	e, err := s.stat(name)
+	if err != nil {
+		return nil, false
+	}
+	sum, ok := e.hashes[algo]
+	return sum, ok
+}
+
+func (s *driveSource) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := s.stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walk(root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk walks name like filepath.Walk's walk does.
+func (s *driveSource) walk(name string, e driveEntry, fn filepath.WalkFunc) error {
+	if !e.dir {
+		return fn(name, e, nil)
+	}
+	entries, err := s.api.list(e)
+	err1 := fn(name, e, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	for _, entry := range entries {
+		child := s.child(name, entry.name)
+		s.remember(child, entry)
+		err := s.walk(child, entry, fn)
+		if err != nil && (!entry.dir || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// driveToken returns the OAuth access token in the environment variable
+// env, which has to be obtained with the provider's tools beforehand.
+func driveToken(env string) (string, error) {
+	token := os.Getenv(env)
+	if token == "" {
+		return "", fmt.Errorf("set %s to an OAuth access token", env)
+	}
+	return token, nil
+}
+
+// driveGet sends an authorized GET request to endpoint and returns the
+// response, which the caller must close.
+func driveGet(endpoint, tokenEnv string) (*http.Response, error) {
+	token, err := driveToken(tokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// driveGetJSON is driveGet decoding the response into v.
+func driveGetJSON(endpoint, tokenEnv string, v any) error {
+	resp, err := driveGet(endpoint, tokenEnv)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// decodeHashes decodes the hex digests reported by a provider, skipping
+// empty and malformed ones.
+func decodeHashes(digests map[string]string) map[string][]byte {
+	hashes := make(map[string][]byte)
+	for algo, digest := range digests {
+		if sum, err := hex.DecodeString(digest); err == nil && len(sum) > 0 {
+			hashes[algo] = sum
+		}
+	}
+	return hashes
+}
+
+// googleDrive is the driveAPI of Google Drive, authorized by the token
+// in MD5SUMMER_DRIVE_TOKEN.
+type googleDrive struct{}
+
+const googleDriveTokenEnv = "MD5SUMMER_DRIVE_TOKEN"
+
+// googleDriveFile is a File resource of the Drive API v3.
+type googleDriveFile struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	MimeType       string    `json:"mimeType"`
+	Size           int64     `json:"size,string"`
+	ModifiedTime   time.Time `json:"modifiedTime"`
+	MD5Checksum    string    `json:"md5Checksum"`
+	SHA1Checksum   string    `json:"sha1Checksum"`
+	SHA256Checksum string    `json:"sha256Checksum"`
+}
+
+const googleDriveFields = "id,name,mimeType,size,modifiedTime,md5Checksum,sha1Checksum,sha256Checksum"
+
+func (f googleDriveFile) entry() driveEntry {
+	return driveEntry{
+		id:       f.ID,
+		name:     f.Name,
+		dir:      f.MimeType == "application/vnd.google-apps.folder",
+		size:     f.Size,
+		modified: f.ModifiedTime,
+		hashes: decodeHashes(map[string]string{
+			"md5":    f.MD5Checksum,
+			"sha1":   f.SHA1Checksum,
+			"sha256": f.SHA256Checksum,
+		}),
+	}
+}
+
+func (googleDrive) root() (driveEntry, error) {
+	var f googleDriveFile
+	err := driveGetJSON(googleDriveAPI+"/files/root?fields="+googleDriveFields, googleDriveTokenEnv, &f)
+	return f.entry(), err
+}
+
+func (googleDrive) list(dir driveEntry) ([]driveEntry, error) {
+	var entries []driveEntry
+	pageToken := ""
+	for {
+		query := url.Values{
+			"q":        {fmt.Sprintf("'%s' in parents and trashed = false", dir.id)},
+			"fields":   {"nextPageToken,files(" + googleDriveFields + ")"},
+			"pageSize": {"1000"},
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			NextPageToken string            `json:"nextPageToken"`
+			Files         []googleDriveFile `json:"files"`
+		}
+		if err := driveGetJSON(googleDriveAPI+"/files?"+query.Encode(), googleDriveTokenEnv, &page); err != nil {
+			return nil, err
+		}
+		for _, f := range page.Files {
+			if f.MimeType != "application/vnd.google-apps.folder" && strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+				// Docs, Sheets and the like have no content of
+				// their own to checksum
+				continue
+			}
+			entries = append(entries, f.entry())
+		}
+		if page.NextPageToken == "" {
+			return entries, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (googleDrive) open(file driveEntry) (io.ReadCloser, error) {
+	resp, err := driveGet(googleDriveAPI+"/files/"+url.PathEscape(file.id)+"?alt=media", googleDriveTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// oneDrive is the driveAPI of Microsoft OneDrive, through the Graph API
+// and authorized by the token in MD5SUMMER_ONEDRIVE_TOKEN.
+type oneDrive struct{}
+
+const oneDriveTokenEnv = "MD5SUMMER_ONEDRIVE_TOKEN"
+
+// oneDriveItem is a driveItem resource of the Graph API.
+type oneDriveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	Folder               *struct{} `json:"folder"`
+	File                 *struct {
+		Hashes struct {
+			SHA1Hash   string `json:"sha1Hash"`
+			SHA256Hash string `json:"sha256Hash"`
+		} `json:"hashes"`
+	} `json:"file"`
+}
+
+func (item oneDriveItem) entry() driveEntry {
+	e := driveEntry{
+		id:       item.ID,
+		name:     item.Name,
+		dir:      item.Folder != nil,
+		size:     item.Size,
+		modified: item.LastModifiedDateTime,
+	}
+	if item.File != nil {
+		e.hashes = decodeHashes(map[string]string{
+			"sha1":   item.File.Hashes.SHA1Hash,
+			"sha256": item.File.Hashes.SHA256Hash,
+		})
+	}
+	return e
+}
+
+func (oneDrive) root() (driveEntry, error) {
+	var item oneDriveItem
+	err := driveGetJSON(oneDriveAPI+"/me/drive/root", oneDriveTokenEnv, &item)
+	return item.entry(), err
+}
+
+func (oneDrive) list(dir driveEntry) ([]driveEntry, error) {
+	var entries []driveEntry
+	next := oneDriveAPI + "/me/drive/items/" + url.PathEscape(dir.id) + "/children?$top=1000"
+	for next != "" {
+		var page struct {
+			Value    []oneDriveItem `json:"value"`
+			NextLink string         `json:"@odata.nextLink"`
+		}
+		if err := driveGetJSON(next, oneDriveTokenEnv, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Value {
+			if item.Folder == nil && item.File == nil {
+				// packages like OneNote notebooks
+				continue
+			}
+			entries = append(entries, item.entry())
+		}
+		next = page.NextLink
+	}
+	return entries, nil
+}
+
+func (oneDrive) open(file driveEntry) (io.ReadCloser, error) {
+	// redirects to a short-lived download URL
+	resp, err := driveGet(oneDriveAPI+"/me/drive/items/"+url.PathEscape(file.id)+"/content", oneDriveTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,137 @@
+package md5summer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// dupeCluster is a set of files with the same digest and size, all but
+// one of which could go.
+type dupeCluster struct {
+	sum   []byte
+	size  int64
+	paths []string
+	// the paths that are hard links of an earlier path, to that path
+	links map[string]string
+}
+
+// redundant is the number of files that could go, hard links of files
+// that stay not counting.
+func (c dupeCluster) redundant() int {
+	return len(c.paths) - len(c.links) - 1
+}
+
+// reclaimable is the number of bytes removing all but one of the files
+// would free. Removing a hard link frees nothing.
+func (c dupeCluster) reclaimable() int64 {
+	return c.size * int64(c.redundant())
+}
+
+// findHardLinks sets the links of c, by what stat tells of its paths.
+// Paths that can't be stat'ed, such as those of remote trees, are taken
+// for files of their own.
+func (c *dupeCluster) findHardLinks(stat func(string) (os.FileInfo, error)) {
+	var firsts []string
+	var infos []os.FileInfo
+	for _, path := range c.paths {
+		info, err := stat(path)
+		if err != nil {
+			continue
+		}
+		linked := false
+		for ii, first := range infos {
+			if os.SameFile(first, info) {
+				if c.links == nil {
+					c.links = make(map[string]string)
+				}
+				c.links[path] = firsts[ii]
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			firsts = append(firsts, path)
+			infos = append(infos, info)
+		}
+	}
+}
+
+// findDupes returns the clusters of files in sums of at least minSize
+// bytes, those with the most reclaimable bytes first. The paths of a
+// cluster are sorted. Hard links are found with stat, and clusters of
+// nothing but links of one file left out.
+func findDupes(sums []checksum, minSize int64, stat func(string) (os.FileInfo, error)) []dupeCluster {
+	type key struct {
+		sum  string
+		size int64
+	}
+	index := make(map[key]int)
+	var clusters []dupeCluster
+	for _, cs := range sums {
+		if cs.size < minSize {
+			continue
+		}
+		k := key{string(cs.sum), cs.size}
+		ii, ok := index[k]
+		if !ok {
+			ii = len(clusters)
+			index[k] = ii
+			clusters = append(clusters, dupeCluster{sum: cs.sum, size: cs.size})
+		}
+		clusters[ii].paths = append(clusters[ii].paths, cs.filepath)
+	}
+	dupes := clusters[:0]
+	for _, c := range clusters {
+		if len(c.paths) < 2 {
+			continue
+		}
+		sort.Strings(c.paths)
+		c.findHardLinks(stat)
+		if c.redundant() > 0 {
+			dupes = append(dupes, c)
+		}
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		if dupes[i].reclaimable() != dupes[j].reclaimable() {
+			return dupes[i].reclaimable() > dupes[j].reclaimable()
+		}
+		return string(dupes[i].sum) < string(dupes[j].sum)
+	})
+	return dupes
+}
+
+// writeDupes writes every cluster as a line with its digest, the size of
+// its files and the bytes reclaimable, followed by one indented line per
+// path, noting hard links, and the totals at the end.
+func writeDupes(w io.Writer, clusters []dupeCluster) error {
+	files, bytes := 0, int64(0)
+	for _, c := range clusters {
+		links := ""
+		switch len(c.links) {
+		case 0:
+		case 1:
+			links = ", 1 hard link"
+		default:
+			links = fmt.Sprintf(", %d hard links", len(c.links))
+		}
+		if _, err := fmt.Fprintf(w, "%s %d files of %s%s, %s reclaimable\n", base64.StdEncoding.EncodeToString(c.sum), len(c.paths), humanBytes(c.size), links, humanBytes(c.reclaimable())); err != nil {
+			return err
+		}
+		for _, path := range c.paths {
+			line := "  " + path
+			if first, ok := c.links[path]; ok {
+				line += " (hard link of " + first + ")"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		files += c.redundant()
+		bytes += c.reclaimable()
+	}
+	_, err := fmt.Fprintf(w, "%d sets of duplicates, %d redundant files, %s reclaimable\n", len(clusters), files, humanBytes(bytes))
+	return err
+}
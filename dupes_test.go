@@ -0,0 +1,59 @@
+package md5summer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindDupesHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	link := func(old, name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.Link(old, path); err != nil {
+			t.Skip("no hard links here:", err)
+		}
+		return path
+	}
+	a := write("a", "copied")
+	b := write("b", "copied")
+	c := link(a, "c")
+	x := write("x", "linked")
+	y := link(x, "y")
+	sums := []checksum{
+		{filepath: c, sum: []byte("1"), size: 6},
+		{filepath: a, sum: []byte("1"), size: 6},
+		{filepath: b, sum: []byte("1"), size: 6},
+		{filepath: x, sum: []byte("2"), size: 6},
+		{filepath: y, sum: []byte("2"), size: 6},
+	}
+	dupes := findDupes(sums, 1, os.Stat)
+	// x and y are one file, there is nothing to reclaim
+	if len(dupes) != 1 {
+		t.Fatalf("got %d clusters, want 1: %+v", len(dupes), dupes)
+	}
+	d := dupes[0]
+	if !slices.Equal(d.paths, []string{a, b, c}) {
+		t.Errorf("got paths %v", d.paths)
+	}
+	if d.links[c] != a || len(d.links) != 1 {
+		t.Errorf("got links %v, want %s to %s", d.links, c, a)
+	}
+	if d.redundant() != 1 || d.reclaimable() != 6 {
+		t.Errorf("got %d redundant files of %d bytes, want 1 of 6", d.redundant(), d.reclaimable())
+	}
+
+	// paths that can't be stat'ed are files of their own
+	dupes = findDupes(sums, 1, func(string) (os.FileInfo, error) { return nil, os.ErrNotExist })
+	if len(dupes) != 2 || dupes[0].reclaimable() != 12 {
+		t.Errorf("without stat got %+v", dupes)
+	}
+}
@@ -0,0 +1,59 @@
+package md5summer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// duplicatePolicies are the values of -on-duplicate.
+var duplicatePolicies = []string{"merge", "warn", "error"}
+
+// duplicates catches a path checksummed more than once in a run, which
+// overlapping roots, bind mounts or listings of remote stores can cause,
+// and decides by its policy which records are kept:
+//
+//   - merge drops repeated records with the same digest, conflicting
+//     digests fail the run
+//   - warn keeps the first record and logs every repeat
+//   - error fails the run at the first repeat
+//
+// It is called with the lock of the checksums it belongs to held.
+type duplicates struct {
+	policy string
+	// digest of every path seen so far
+	seen map[string][]byte
+	// set once the run has to fail
+	err error
+}
+
+func newDuplicates(policy string) (*duplicates, error) {
+	for _, p := range duplicatePolicies {
+		if p == policy {
+			return &duplicates{policy: policy, seen: make(map[string][]byte)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown policy '%s', expected merge, warn or error", policy)
+}
+
+// keep reports whether the record cs is to be written.
+func (d *duplicates) keep(cs checksum) bool {
+	prev, ok := d.seen[cs.filepath]
+	if !ok {
+		d.seen[cs.filepath] = cs.sum
+		return true
+	}
+	same := bytes.Equal(prev, cs.sum)
+	switch {
+	case d.policy == "merge" && same:
+	case d.policy == "warn" && same:
+		log.Printf("'%s' was checksummed twice, keeping the first record", cs.filepath)
+	case d.policy == "warn":
+		log.Printf("'%s' was checksummed twice with different digests, keeping the first record", cs.filepath)
+	case d.err == nil && same:
+		d.err = fmt.Errorf("'%s' was checksummed twice", cs.filepath)
+	case d.err == nil:
+		d.err = fmt.Errorf("'%s' was checksummed twice with different digests", cs.filepath)
+	}
+	return false
+}
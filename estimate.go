@@ -0,0 +1,137 @@
+package md5summer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recordOverhead approximates the memory a collected checksum takes on
+// top of its path: the struct, the digest and slice growth.
+const recordOverhead = 120
+
+// treeStats describes a tree without reading any file contents.
+type treeStats struct {
+	files     int
+	bytes     int64
+	pathBytes int64
+	// number of files by size, bucket i holds sizes below 1024^(i+1)
+	buckets [5]int
+	paths   []string
+}
+
+func statTree(root string) (treeStats, error) {
+	var ts treeStats
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			accessLog.record("list", path, 0, nil)
+			return nil
+		}
+		ts.files++
+		ts.bytes += info.Size()
+		ts.pathBytes += int64(len(path))
+		ts.paths = append(ts.paths, path)
+		ii := 0
+		for limit := int64(1024); info.Size() >= limit && ii < len(ts.buckets)-1; limit *= 1024 {
+			ii++
+		}
+		ts.buckets[ii]++
+		return nil
+	})
+	return ts, err
+}
+
+// probe reads up to limit bytes from a random sample of paths using n
+// concurrent readers and reports the bytes read, the number of files
+// opened and the time it took.
+func probe(paths []string, limit int64, n int) (int64, int, time.Duration) {
+	sample := make([]string, len(paths))
+	copy(sample, paths)
+	rand.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+
+	var lk sync.Mutex
+	var read int64
+	opened := 0
+	next := func() (string, bool) {
+		lk.Lock()
+		defer lk.Unlock()
+		if read >= limit || opened >= len(sample) {
+			return "", false
+		}
+		opened++
+		return sample[opened-1], true
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for ii := 0; ii < n; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path, ok := next(); ok; path, ok = next() {
+				f, err := openRead(path)
+				if err != nil {
+					continue
+				}
+				m, _ := io.Copy(io.Discard, io.LimitReader(f, limit))
+				f.Close()
+				lk.Lock()
+				read += m
+				lk.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return read, opened, time.Since(start)
+}
+
+// runEstimate implements the estimate subcommand.
+func runEstimate(args []string) {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	rootdir := fs.String("dir", ".", "directory to estimate a run for")
+	sampleMB := fs.Int64("sample", 256, "megabytes to read when probing the read speed")
+	fs.IntVar(&numWorkers, "workers", numWorkers, "number of files the run would read at a time")
+	fs.Parse(args)
+	if numWorkers < 1 {
+		panic(fmt.Errorf("-workers must be at least 1"))
+	}
+
+	root, err := filepath.Abs(*rootdir)
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", *rootdir, err))
+	}
+	ts, err := statTree(root)
+	if err != nil {
+		panic(fmt.Errorf("could not walk '%s': %v", root, err))
+	}
+	read, opened, took := probe(ts.paths, *sampleMB<<20, numWorkers)
+
+	w := os.Stdout
+	fmt.Fprintf(w, "files     %d\n", ts.files)
+	fmt.Fprintf(w, "bytes     %s\n", humanBytes(ts.bytes))
+	fmt.Fprintf(w, "sizes     <1 KiB: %d, <1 MiB: %d, <1 GiB: %d, <1 TiB: %d, larger: %d\n",
+		ts.buckets[0], ts.buckets[1], ts.buckets[2], ts.buckets[3], ts.buckets[4])
+	if read == 0 || took == 0 {
+		fmt.Fprintln(w, "probe     nothing could be read, no estimate")
+		return
+	}
+	// time per byte from the probe, plus the per-file cost of the files
+	// that were opened without contributing many bytes
+	rate := float64(read) / took.Seconds()
+	perFile := took / time.Duration(opened)
+	eta := time.Duration(float64(ts.bytes)/rate*float64(time.Second)) + perFile*time.Duration(ts.files-opened)/time.Duration(numWorkers)
+	fmt.Fprintf(w, "probe     %s from %d files at %s/s with %d workers (cached data reads faster)\n",
+		humanBytes(read), opened, humanBytes(int64(rate)), numWorkers)
+	fmt.Fprintf(w, "run time  %s\n", eta.Round(time.Second))
+	fmt.Fprintf(w, "memory    %s sorted, %s with -no-sort\n",
+		humanBytes(ts.pathBytes+int64(ts.files)*recordOverhead+int64(numWorkers)*32<<10),
+		humanBytes(int64(numWorkers)*32<<10))
+}
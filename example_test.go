@@ -0,0 +1,36 @@
+package md5summer_test
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"testing/fstest"
+
+	"github.com/gpaul/md5summer"
+)
+
+func ExampleVerify() {
+	tree := fstest.MapFS{
+		"a.txt": {Data: []byte("hello\n")},
+		"b.txt": {Data: []byte("changed\n")},
+	}
+	hello, other := md5.Sum([]byte("hello\n")), md5.Sum([]byte("original\n"))
+	m := md5summer.NewManifest("md5")
+	m.Set("a.txt", hello[:])
+	m.Set("b.txt", other[:])
+	m.Set("c.txt", other[:])
+
+	report, err := md5summer.Verify(context.Background(), m, ".", md5summer.VerifyOptions{FS: tree})
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range report.Files {
+		fmt.Println(f.Path, f.Verdict)
+	}
+	fmt.Println(report.OK(), report.Counts[md5summer.VerdictFailed])
+	// Output:
+	// a.txt OK
+	// b.txt FAILED
+	// c.txt MISSING
+	// false 1
+}
@@ -0,0 +1,18 @@
+package md5summer
+
+// Exit statuses shared by every mode that compares a tree against
+// something else, so scripts can branch on the outcome without parsing
+// the output. An unrecovered panic also exits with status 2, which keeps
+// fatal errors consistent with exitError.
+const (
+	// the tree is identical to what it was compared against
+	exitIdentical = 0
+	// the comparison completed and found differences
+	exitDifferent = 1
+	// the comparison could not be completed
+	exitError = 2
+	// the run was interrupted by a signal, the output covers only the
+	// files finished before. Shells report processes killed by SIGINT
+	// the same way.
+	exitInterrupted = 130
+)
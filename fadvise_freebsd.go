@@ -0,0 +1,24 @@
+//go:build freebsd && (amd64 || arm64 || riscv64)
+
+package md5summer
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadviseSupported = true
+
+// adviseSequential tells the kernel the whole file is about to be read
+// front to back. It is only a hint, failures are ignored.
+func adviseSequential(f *os.File) {
+	const posixFadvSequential = 2
+	syscall.Syscall6(syscall.SYS_POSIX_FADVISE, f.Fd(), 0, 0, posixFadvSequential, 0, 0)
+}
+
+// dropCached asks the kernel to drop the cached pages of the file, so
+// the next read goes to the disk.
+func dropCached(f *os.File) {
+	const posixFadvDontneed = 4
+	syscall.Syscall6(syscall.SYS_POSIX_FADVISE, f.Fd(), 0, 0, posixFadvDontneed, 0, 0)
+}
@@ -0,0 +1,25 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+
+package md5summer
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadviseSupported = true
+
+// adviseSequential tells the kernel the whole file is about to be read
+// front to back, which doubles the readahead window on Linux. It is
+// only a hint, failures are ignored.
+func adviseSequential(f *os.File) {
+	const fadvSequential = 2
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvSequential, 0, 0)
+}
+
+// dropCached asks the kernel to drop the cached pages of the file, so
+// the next read goes to the disk. Pages still being written stay.
+func dropCached(f *os.File) {
+	const fadvDontneed = 4
+	syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontneed, 0, 0)
+}
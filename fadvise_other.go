@@ -0,0 +1,15 @@
+//go:build !(linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)) && !(freebsd && (amd64 || arm64 || riscv64))
+
+package md5summer
+
+import "os"
+
+const fadviseSupported = false
+
+// adviseSequential is a no-op where there is no fadvise, or where its
+// 64-bit offsets would have to be split across registers.
+func adviseSequential(f *os.File) {}
+
+// dropCached is a no-op without fadvise, reads may then be served from
+// the cache.
+func dropCached(f *os.File) {}
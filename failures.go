@@ -0,0 +1,85 @@
+package md5summer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// fileError is a file or directory that couldn't be read.
+type fileError struct {
+	path string
+	err  error
+}
+
+// fileErrors collects the files a walk couldn't checksum when it keeps
+// going past them, set up by -keep-going.
+type fileErrors struct {
+	lk   sync.Mutex
+	errs []fileError
+}
+
+func (fe *fileErrors) add(path string, err error) {
+	fe.lk.Lock()
+	fe.errs = append(fe.errs, fileError{path, err})
+	fe.lk.Unlock()
+}
+
+// count returns the number of failures, none for a nil *fileErrors.
+func (fe *fileErrors) count() int {
+	if fe == nil {
+		return 0
+	}
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	return len(fe.errs)
+}
+
+// list returns the failures ordered by path.
+func (fe *fileErrors) list() []fileError {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	sort.Slice(fe.errs, func(i, j int) bool { return fe.errs[i].path < fe.errs[j].path })
+	return fe.errs
+}
+
+// fileFailed reports that path couldn't be read. The walk stops, unless
+// it keeps going past such files, or it is being cancelled anyway.
+func fileFailed(c ctrl, path string, err error) {
+	if c.failures == nil || c.ctx.Err() != nil {
+		notifyErr(c, err)
+		return
+	}
+	c.failures.add(path, err)
+}
+
+func (f fileError) String() string {
+	// the path is there already
+	err := f.err
+	var pe *fs.PathError
+	if errors.As(err, &pe) && pe.Path == f.path {
+		err = pe.Err
+	}
+	return fmt.Sprintf("%s: %v", f.path, err)
+}
+
+// reportFailures logs the files that couldn't be read, if any, and
+// exits with exitError then.
+func reportFailures(fe *fileErrors) {
+	if fe == nil {
+		return
+	}
+	list := fe.list()
+	if len(list) == 0 {
+		return
+	}
+	for _, f := range list {
+		log.Printf("could not read %s", f)
+	}
+	log.Printf("%d files or directories could not be read", len(list))
+	os.Exit(exitError)
+}
@@ -0,0 +1,203 @@
+package md5summer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errFetchMismatch is returned when a download doesn't match its digest.
+var errFetchMismatch = errors.New("digest mismatch")
+
+// runFetch implements the fetch subcommand: it downloads a URL to a
+// file, hashing it as it is written, and only keeps the file if it
+// matches the digests sent in the Repr-Digest and Content-MD5 headers
+// and the one given with -expect.
+func runFetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	output := fs.String("o", "", "file to write, the last element of the URL path if empty")
+	expect := fs.String("expect", "", "expected digest as [ALGO:]HEX or [ALGO:]BASE64, the algorithm is told by the length if omitted")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer fetch [-o FILE] [-expect DIGEST] URL")
+		fs.PrintDefaults()
+	}
+	// the URL usually comes first, flags after it are still flags
+	var positional []string
+	for fs.Parse(args); fs.NArg() > 0; fs.Parse(args) {
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	url := positional[0]
+	if *output == "" {
+		if *output = path.Base(strings.SplitN(url, "?", 2)[0]); *output == "/" || *output == "." || strings.HasSuffix(url, "/") {
+			panic(fmt.Errorf("cannot tell a file name from '%s', use -o", url))
+		}
+	}
+	expected := make(map[string][]byte)
+	if *expect != "" {
+		algo, sum, err := parseExpectedDigest(*expect)
+		if err != nil {
+			panic(fmt.Errorf("invalid -expect: %v", err))
+		}
+		expected[algo] = sum
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		panic(fmt.Errorf("invalid URL '%s': %v", url, err))
+	}
+	// the digests are of the representation, which a transparently
+	// decompressed body isn't
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(fmt.Errorf("cannot fetch '%s': %v", url, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Errorf("cannot fetch '%s': %s", url, resp.Status))
+	}
+	if err := headerDigests(resp.Header, expected); errors.Is(err, errFetchMismatch) {
+		log.Printf("%s: %v", url, err)
+		os.Exit(exitDifferent)
+	} else if err != nil {
+		panic(fmt.Errorf("cannot fetch '%s': %v", url, err))
+	}
+	if len(expected) == 0 {
+		panic(fmt.Errorf("'%s' sent no digest to verify against, pass one with -expect", url))
+	}
+
+	// the file only appears under its name once it is verified
+	var algos []string
+	for algo := range expected {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	var size int64
+	actual := make(map[string][]byte)
+	err = writeAtomic(*output, func(w io.Writer) error {
+		hs := make([]hash.Hash, len(algos))
+		writers := []io.Writer{w}
+		for ii, algo := range algos {
+			hs[ii], _ = newHash(algo)
+			writers = append(writers, hs[ii])
+		}
+		n, err := io.Copy(io.MultiWriter(writers...), resp.Body)
+		if err != nil {
+			return err
+		}
+		size = n
+		for ii, algo := range algos {
+			actual[algo] = hs[ii].Sum(nil)
+			if !bytes.Equal(actual[algo], expected[algo]) {
+				return errFetchMismatch
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errFetchMismatch) {
+		for _, algo := range algos {
+			log.Printf("%s: %s expected %s, got %s", url, algo, hex.EncodeToString(expected[algo]), hex.EncodeToString(actual[algo]))
+		}
+		log.Printf("discarded %s", *output)
+		os.Exit(exitDifferent)
+	}
+	if err != nil {
+		panic(fmt.Errorf("cannot fetch '%s' to '%s': %v", url, *output, err))
+	}
+	log.Printf("verified %s against %s", *output, strings.Join(algos, ", "))
+	mtime := time.Now()
+	if info, err := os.Stat(*output); err == nil {
+		mtime = info.ModTime()
+	}
+	cs := checksum{*output, actual[algos[0]], size, mtime, algos[0], nil}
+	fmt.Println(cs.String())
+}
+
+// parseExpectedDigest parses a digest given as [ALGO:]HEX or
+// [ALGO:]BASE64.
+func parseExpectedDigest(s string) (string, []byte, error) {
+	algo, digest, tagged := strings.Cut(s, ":")
+	if !tagged {
+		algo, digest = "", s
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		if sum, err = base64.StdEncoding.DecodeString(digest); err != nil {
+			return "", nil, fmt.Errorf("'%s' is neither hex nor base64", digest)
+		}
+	}
+	if !tagged {
+		var ok bool
+		if algo, ok = algoForDigest(sum); !ok {
+			return "", nil, fmt.Errorf("no algorithm has digests of %d bytes", len(sum))
+		}
+	}
+	h, err := newHash(algo)
+	if err != nil {
+		return "", nil, err
+	}
+	if h.Size() != len(sum) {
+		return "", nil, fmt.Errorf("%s digests have %d bytes, not %d", algo, h.Size(), len(sum))
+	}
+	return algo, sum, nil
+}
+
+// headerDigests adds the digests in the Repr-Digest and Content-MD5
+// headers of a response to expected. Algorithms that aren't supported
+// are skipped, digests that disagree with ones already known are an
+// error.
+func headerDigests(h http.Header, expected map[string][]byte) error {
+	add := func(algo string, sum []byte) error {
+		if known, ok := expected[algo]; ok && !bytes.Equal(known, sum) {
+			return fmt.Errorf("%w, the %s digest sent is %s, not %s", errFetchMismatch, algo, hex.EncodeToString(sum), hex.EncodeToString(known))
+		}
+		expected[algo] = sum
+		return nil
+	}
+	for _, field := range h.Values("Repr-Digest") {
+		for _, member := range strings.Split(field, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				return fmt.Errorf("malformed Repr-Digest '%s'", field)
+			}
+			sum, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+			if err != nil {
+				return fmt.Errorf("malformed Repr-Digest '%s'", field)
+			}
+			for algo, names := range digestNames {
+				if strings.EqualFold(names[0], name) {
+					if err := add(algo, sum); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if v := h.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != 16 {
+			return fmt.Errorf("malformed Content-MD5 '%s'", v)
+		}
+		if err := add("md5", sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
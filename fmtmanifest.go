@@ -0,0 +1,115 @@
+package md5summer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runFmt implements the fmt subcommand: it reads one or more manifests and
+// prints them as one canonical manifest, with clean slash-separated paths,
+// sorted, without duplicates and with every digest encoded the same way,
+// so manifests assembled from several sources can be diffed.
+func runFmt(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer fmt [-w] [-format FORMAT] [-trailer] MANIFEST...")
+		fs.PrintDefaults()
+	}
+	var inPlace, withTrailer, backslashes bool
+	var format string
+	fs.BoolVar(&inPlace, "w", false, "rewrite the manifest in place instead of printing it, only for a single manifest")
+	fs.StringVar(&format, "format", "plain", "format of the records: plain, gnu or gnu-binary")
+	fs.BoolVar(&withTrailer, "trailer", false, "write the entry count and a digest of the records at the end")
+	fs.BoolVar(&backslashes, "backslashes", false, "treat backslashes in paths as separators, for manifests written on Windows")
+	fs.Parse(args)
+	if fs.NArg() == 0 || (inPlace && fs.NArg() != 1) {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	recordFormat, ok := recordFormats[format]
+	if !ok || (format != "plain" && format != "gnu" && format != "gnu-binary") {
+		panic(fmt.Errorf("-format must be one of plain, gnu or gnu-binary"))
+	}
+	if withTrailer && !recordFormat.comments {
+		panic(fmt.Errorf("-trailer cannot be combined with -format %s", format))
+	}
+
+	algo := ""
+	sums := make(map[string][]byte)
+	duplicates, conflicts := 0, 0
+	for _, name := range fs.Args() {
+		manifestAlgo, err := scanManifest(name, func(p string, sum []byte) error {
+			p = normalizePath(p, backslashes)
+			prev, ok := sums[p]
+			switch {
+			case !ok:
+				sums[p] = sum
+			case bytes.Equal(prev, sum):
+				duplicates++
+			default:
+				log.Printf("'%s' has conflicting digests", p)
+				conflicts++
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			panic(fmt.Errorf("cannot read manifest '%s': %v", name, err))
+		}
+		if algo == "" {
+			algo = manifestAlgo
+		} else if manifestAlgo != "" && manifestAlgo != algo {
+			panic(fmt.Errorf("'%s' holds %s digests, the other manifests %s digests", name, manifestAlgo, algo))
+		}
+	}
+	if conflicts > 0 {
+		// picking one of them would hide that the sources disagree
+		panic(fmt.Errorf("%d paths have conflicting digests", conflicts))
+	}
+	if duplicates > 0 {
+		log.Printf("dropped %d duplicate records", duplicates)
+	}
+
+	write := func(w io.Writer) error {
+		body := md5.New()
+		records := &recordWriter{w: io.MultiWriter(w, body), format: recordFormat}
+		for _, p := range sortedKeys(sums) {
+			if err := records.write(&checksum{p, sums[p], 0, time.Time{}, algo, nil}); err != nil {
+				return err
+			}
+		}
+		if err := records.close(); err != nil {
+			return err
+		}
+		if withTrailer {
+			return trailer{records.n, body.Sum(nil)}.writeTo(w)
+		}
+		return nil
+	}
+	var err error
+	if inPlace {
+		err = writeAtomic(fs.Arg(0), write)
+	} else {
+		err = write(os.Stdout)
+	}
+	if err != nil {
+		panic(fmt.Errorf("could not write manifest: %v", err))
+	}
+}
+
+// normalizePath returns the canonical form of a manifest path: slash
+// separated, without empty, "." or resolvable ".." elements.
+func normalizePath(p string, backslashes bool) string {
+	if backslashes {
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	return path.Clean(filepath.ToSlash(p))
+}
@@ -0,0 +1,134 @@
+package md5summer
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordFormat describes how the records of a manifest are written.
+type recordFormat struct {
+	// formats a single record, without the trailing newline
+	record func(*checksum) string
+	// written before the first and after the last record
+	begin, end string
+	// written after every record but the last one
+	separator string
+	// whether '#' lines such as the header and trailer can be mixed in
+	comments bool
+}
+
+// recordFormats maps the values of -format to their formats.
+var recordFormats = map[string]recordFormat{
+	"plain":    {record: (*checksum).String, comments: true},
+	"certutil": {record: certutilRecord, comments: true},
+	"json":     {record: jsonRecord, begin: "[\n", end: "]\n", separator: ","},
+	"ndjson":   {record: jsonRecord},
+	"csv":      {record: csvRecord, begin: "path,algorithm,hex,base64,size,mtime\n"},
+	// md5sum -c rejects the header and trailer lines with --strict
+	"gnu":        {record: func(cs *checksum) string { return gnuModeLine(cs.sum, cs.filepath, ' ') }},
+	"gnu-binary": {record: func(cs *checksum) string { return gnuModeLine(cs.sum, cs.filepath, '*') }},
+}
+
+// formatNames returns the values of -format for messages.
+func formatNames() string {
+	var names []string
+	for name := range recordFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// certutilRecord formats a record exactly like 'certutil -hashfile path
+// MD5' prints it on Windows, so single files can be cross-checked with
+// the built-in tool. Further digests follow as if certutil ran again.
+func certutilRecord(cs *checksum) string {
+	record := func(algo string, sum []byte) string {
+		return fmt.Sprintf("%s hash of %s:\n%s\nCertUtil: -hashfile command completed successfully.", strings.ToUpper(algo), cs.filepath, hex.EncodeToString(sum))
+	}
+	records := []string{record(cs.algo, cs.sum)}
+	for _, d := range cs.extra {
+		records = append(records, record(d.algo, d.sum))
+	}
+	return strings.Join(records, "\n")
+}
+
+// jsonRecord formats a record as a single line JSON object. Further
+// digests are listed in hex by algorithm.
+func jsonRecord(cs *checksum) string {
+	var extra map[string]string
+	for _, d := range cs.extra {
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[d.algo] = hex.EncodeToString(d.sum)
+	}
+	b, err := json.Marshal(struct {
+		Path      string            `json:"path"`
+		Algorithm string            `json:"algorithm"`
+		Hex       string            `json:"hex"`
+		Base64    string            `json:"base64"`
+		Size      int64             `json:"size"`
+		Mtime     time.Time         `json:"mtime"`
+		Digests   map[string]string `json:"digests,omitempty"`
+	}{cs.filepath, cs.algo, hex.EncodeToString(cs.sum), base64.StdEncoding.EncodeToString(cs.sum), cs.size, cs.mtime.UTC(), extra})
+	if err != nil {
+		// none of the fields can fail to marshal
+		panic(err)
+	}
+	return string(b)
+}
+
+// csvRecord formats a record as a CSV row, quoting the path as needed.
+func csvRecord(cs *checksum) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{
+		cs.filepath,
+		cs.algo,
+		hex.EncodeToString(cs.sum),
+		base64.StdEncoding.EncodeToString(cs.sum),
+		strconv.FormatInt(cs.size, 10),
+		cs.mtime.UTC().Format(time.RFC3339Nano),
+	})
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// recordWriter writes records one at a time in a format. The newline of
+// a record is held back until it is known whether a separator follows.
+type recordWriter struct {
+	w      io.Writer
+	format recordFormat
+	n      int
+}
+
+func (rw *recordWriter) write(cs *checksum) error {
+	prefix := rw.format.begin
+	if rw.n > 0 {
+		prefix = rw.format.separator + "\n"
+	}
+	rw.n++
+	_, err := io.WriteString(rw.w, prefix+rw.format.record(cs))
+	return err
+}
+
+// close ends the last record and writes the end of the format.
+func (rw *recordWriter) close() error {
+	suffix := rw.format.end
+	if rw.n == 0 {
+		suffix = rw.format.begin + suffix
+	} else {
+		suffix = "\n" + suffix
+	}
+	_, err := io.WriteString(rw.w, suffix)
+	return err
+}
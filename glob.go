@@ -0,0 +1,110 @@
+package md5summer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// pathFilter decides which files of a walk are checksummed by glob
+// patterns on their paths relative to the root, set up by -include and
+// -exclude. A nil pathFilter lets everything through.
+type pathFilter struct {
+	include, exclude []string
+}
+
+// newPathFilter checks and prepares the patterns. Patterns without a
+// slash match the base name at any depth.
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &pathFilter{}
+	for _, l := range []struct {
+		patterns []string
+		to       *[]string
+	}{{include, &f.include}, {exclude, &f.exclude}} {
+		for _, p := range l.patterns {
+			p = strings.TrimPrefix(p, "./")
+			for _, part := range strings.Split(p, "/") {
+				if _, err := path.Match(part, ""); err != nil {
+					return nil, fmt.Errorf("bad pattern '%s'", p)
+				}
+			}
+			if !strings.Contains(p, "/") {
+				p = "**/" + p
+			}
+			*l.to = append(*l.to, p)
+		}
+	}
+	return f, nil
+}
+
+// skipDir reports whether the directory rel, relative to the root and
+// slash separated, is excluded as a whole.
+func (f *pathFilter) skipDir(rel string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.exclude {
+		if globMatch(p, rel) || strings.HasSuffix(p, "/**") && globMatch(strings.TrimSuffix(p, "/**"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipFile reports whether the file rel, relative to the root and slash
+// separated, is excluded or not included.
+func (f *pathFilter) skipFile(rel string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.exclude {
+		if globMatch(p, rel) {
+			return true
+		}
+	}
+	if len(f.include) == 0 {
+		return false
+	}
+	for _, p := range f.include {
+		if globMatch(p, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// keepFile and keepDir are the filter as the hooks of a walk.
+func (f *pathFilter) keepFile(rel string, info os.FileInfo) bool { return !f.skipFile(rel) }
+func (f *pathFilter) keepDir(rel string, info os.FileInfo) bool  { return !f.skipDir(rel) }
+
+// globMatch reports whether name, a slash separated path, matches
+// pattern. Besides the syntax of path.Match, a '**' component matches
+// any number of components, none included.
+func globMatch(pattern, name string) bool {
+	return matchComponents(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchComponents(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchComponents(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,110 @@
+package md5summer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// gnuEscaper escapes file names the way GNU coreutils does.
+var gnuEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// gnuLine formats a record the way GNU md5sum does: the hex digest, two
+// spaces and the file name. Names containing a backslash, newline or
+// carriage return are escaped and the line is marked with a leading
+// backslash.
+func gnuLine(sum []byte, name string) string {
+	return gnuModeLine(sum, name, ' ')
+}
+
+// gnuModeLine is gnuLine with the mode marker after the first space,
+// '*' being the one md5sum -b writes for binary mode.
+func gnuModeLine(sum []byte, name string, mode byte) string {
+	line := hex.EncodeToString(sum) + " " + string(mode)
+	if strings.ContainsAny(name, "\\\n\r") {
+		return "\\" + line + gnuEscaper.Replace(name)
+	}
+	return line + name
+}
+
+// parseGNULine parses a record written by md5sum, sha256sum and friends.
+// Both the default format
+//
+//	d41d8cd98f00b204e9800998ecf8427e  name
+//	d41d8cd98f00b204e9800998ecf8427e *name
+//
+// where '*' marks binary mode, and the format written with --tag
+//
+//	MD5 (name) = d41d8cd98f00b204e9800998ecf8427e
+//
+// are understood, as is a single space between digest and name as
+// written by BSD md5 -r. A leading backslash marks a name containing
+// escaped backslashes, newlines or carriage returns.
+func parseGNULine(line string) (sum []byte, name string, err error) {
+	line = strings.TrimLeft(line, " \t")
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	var digest string
+	if open := strings.Index(line, " ("); open > 0 && strings.IndexByte(line[:open], ' ') < 0 && !isHex(line[:open]) {
+		// tagged: ALGO (name) = digest
+		close := strings.LastIndex(line, ") = ")
+		if close < open {
+			return nil, "", fmt.Errorf("malformed line %q", line)
+		}
+		name, digest = line[open+2:close], line[close+4:]
+	} else {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+1 == len(line) {
+			return nil, "", fmt.Errorf("malformed line %q", line)
+		}
+		digest, name = line[:i], line[i+1:]
+		// the mode marker is optional, a name can't be empty
+		if len(name) > 1 && (name[0] == ' ' || name[0] == '*') {
+			name = name[1:]
+		}
+	}
+
+	if sum, err = hex.DecodeString(digest); err != nil || len(sum) == 0 {
+		return nil, "", fmt.Errorf("malformed digest %q", digest)
+	}
+	if escaped {
+		if name, err = gnuUnescape(name); err != nil {
+			return nil, "", err
+		}
+	}
+	return sum, name, nil
+}
+
+// gnuUnescape reverses gnuEscaper, rejecting unknown escape sequences.
+func gnuUnescape(s string) (string, error) {
+	var b strings.Builder
+	for ii := 0; ii < len(s); ii++ {
+		if s[ii] != '\\' {
+			b.WriteByte(s[ii])
+			continue
+		}
+		if ii+1 == len(s) {
+			return "", fmt.Errorf("trailing backslash in %q", s)
+		}
+		ii++
+		switch s[ii] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("unknown escape sequence \\%c in %q", s[ii], s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
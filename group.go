@@ -0,0 +1,48 @@
+package md5summer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// group is a set of files sharing the same digest.
+type group struct {
+	sum   []byte
+	paths []string
+}
+
+// groupByDigest collects sums into groups of identical digests. Groups are
+// ordered by digest and keep the order of sums within a group.
+func groupByDigest(sums []checksum) []group {
+	index := make(map[string]int)
+	var groups []group
+	for _, cs := range sums {
+		ii, ok := index[string(cs.sum)]
+		if !ok {
+			ii = len(groups)
+			index[string(cs.sum)] = ii
+			groups = append(groups, group{sum: cs.sum})
+		}
+		groups[ii].paths = append(groups[ii].paths, cs.filepath)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return string(groups[i].sum) < string(groups[j].sum)
+	})
+	return groups
+}
+
+// writeTo writes the digest on a line of its own, followed by one
+// indented line per path.
+func (g group) writeTo(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(g.sum)); err != nil {
+		return err
+	}
+	for _, path := range g.paths {
+		if _, err := fmt.Fprintln(w, "  "+path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// hashes maps algorithm names to their constructors.
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	// not in the standard library, implemented here
+	"blake2b": newBlake2b,
+	"xxhash":  newXXHash64,
+}
+
+// byDigestLength are the algorithms algoForDigest tells apart by the
+// length of their digests. Only the first of those of the same length
+// counts, so bare blake2b digests, as long as sha512 ones, need a header
+// or record prefix to be recognised.
+var byDigestLength = []string{"md5", "sha1", "sha256", "sha512", "crc32c", "xxhash", "blake2b"}
+
+// algoForDigest tells the algorithm of a digest by its length.
+func algoForDigest(sum []byte) (string, bool) {
+	for _, algo := range byDigestLength {
+		if hashes[algo]().Size() == len(sum) {
+			return algo, true
+		}
+	}
+	return "", false
+}
+
+// hashFunc returns the constructor of the named algorithm, one of
+// hashes, a chunked one or, given a key, a keyed one.
+func hashFunc(algo string) (func() hash.Hash, bool) {
+	if fn, ok := hashes[algo]; ok {
+		return fn, true
+	}
+	if base, ok := hmacAlgo(algo); ok && hmacKey != nil {
+		return newHMAC(base), true
+	}
+	if base, size, ok := chunkedAlgo(algo); ok {
+		return func() hash.Hash { return newChunkedHash(hashes[base], size) }, true
+	}
+	return nil, false
+}
+
+// newHash returns a new hash for the named algorithm.
+func newHash(algo string) (hash.Hash, error) {
+	if fn, ok := hashFunc(algo); ok {
+		return fn(), nil
+	}
+	if _, ok := hmacAlgo(algo); ok {
+		return nil, errNoHMACKey
+	}
+	return nil, fmt.Errorf("unknown hash algorithm '%s'", algo)
+}
+
+// multiHash calculates digests in several algorithms of what is written
+// to it, so a file is read once for all of them.
+type multiHash struct {
+	algos  []string
+	hashes []hash.Hash
+}
+
+func newMultiHash(algos []string) (*multiHash, error) {
+	m := &multiHash{algos: algos}
+	for _, algo := range algos {
+		h, err := newHash(algo)
+		if err != nil {
+			return nil, err
+		}
+		m.hashes = append(m.hashes, h)
+	}
+	return m, nil
+}
+
+func (m *multiHash) Write(p []byte) (int, error) {
+	for _, h := range m.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// digests returns the digests in the order of the algorithms, nil for a
+// nil multiHash.
+func (m *multiHash) digests() []digest {
+	if m == nil {
+		return nil
+	}
+	var ds []digest
+	for i, h := range m.hashes {
+		ds = append(ds, digest{m.algos[i], h.Sum(nil)})
+	}
+	return ds
+}
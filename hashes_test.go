@@ -0,0 +1,57 @@
+package md5summer
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHashVectors(t *testing.T) {
+	tests := []struct {
+		algo, input, sum string
+	}{
+		// RFC 7693 and b2sum
+		{"blake2b", "", "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{"blake2b", "abc", "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		// xxhsum -H64
+		{"xxhash", "", "ef46db3751d8e999"},
+		{"xxhash", "a", "d24ec4f1a98c6e5b"},
+		{"xxhash", "abc", "44bc2cf5ad770999"},
+		{"xxhash", "Nobody inspects the spammish repetition", "fbcea83c8a378bf1"},
+	}
+	for _, test := range tests {
+		h, err := newHash(test.algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte(test.input))
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.sum {
+			t.Errorf("%s(%q) = %s, want %s", test.algo, test.input, got, test.sum)
+		}
+	}
+}
+
+// TestHashWrites checks that the digests don't depend on how the input
+// is split into writes, across block and stripe boundaries.
+func TestHashWrites(t *testing.T) {
+	input := []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 20))
+	for _, algo := range []string{"blake2b", "xxhash"} {
+		whole, _ := newHash(algo)
+		for n := range len(input) + 1 {
+			whole.Reset()
+			whole.Write(input[:n])
+			want := whole.Sum(nil)
+			for _, size := range []int{1, 7, 31, 32, 33, 127, 128, 129} {
+				h, _ := newHash(algo)
+				for p := input[:n]; len(p) > 0; {
+					c := min(size, len(p))
+					h.Write(p[:c])
+					p = p[c:]
+				}
+				if got := h.Sum(nil); string(got) != string(want) {
+					t.Fatalf("%s of %d bytes in writes of %d: %x, want %x", algo, n, size, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package md5summer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hdfsSource is a Source reading a Hadoop filesystem through the WebHDFS
+// REST API of its namenode. Paths are URLs of the form
+// hdfs://[user@]namenode:port/path, the user is passed on as user.name
+// for simple authentication.
+type hdfsSource struct {
+	client *http.Client
+}
+
+// hdfsFileStatus is a FileStatus object of the WebHDFS API.
+type hdfsFileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+	// milliseconds since the epoch
+	ModificationTime int64  `json:"modificationTime"`
+	Permission       string `json:"permission"`
+}
+
+// hdfsFileInfo describes a file of a Hadoop filesystem.
+type hdfsFileInfo struct {
+	name   string
+	status hdfsFileStatus
+}
+
+func (fi hdfsFileInfo) Name() string { return fi.name }
+func (fi hdfsFileInfo) Size() int64  { return fi.status.Length }
+func (fi hdfsFileInfo) Mode() os.FileMode {
+	perm, _ := strconv.ParseUint(fi.status.Permission, 8, 32)
+	mode := os.FileMode(perm) & os.ModePerm
+	switch fi.status.Type {
+	case "DIRECTORY":
+		mode |= os.ModeDir
+	case "SYMLINK":
+		mode |= os.ModeSymlink
+	}
+	return mode
+}
+func (fi hdfsFileInfo) ModTime() time.Time { return time.UnixMilli(fi.status.ModificationTime) }
+func (fi hdfsFileInfo) IsDir() bool        { return fi.status.Type == "DIRECTORY" }
+func (fi hdfsFileInfo) Sys() any           { return fi.status }
+
+// endpoint returns the WebHDFS URL for op on the file at name.
+func (hdfsSource) endpoint(name, op string) (string, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "hdfs" || u.Host == "" {
+		return "", fmt.Errorf("'%s' is not an hdfs://namenode:port/path URL", name)
+	}
+	query := url.Values{"op": {op}}
+	if u.User != nil {
+		query.Set("user.name", u.User.Username())
+	}
+	webhdfs := url.URL{
+		Scheme:   "http",
+		Host:     u.Host,
+		Path:     "/webhdfs/v1" + path.Clean("/"+u.Path),
+		RawQuery: query.Encode(),
+	}
+	return webhdfs.String(), nil
+}
+
+// get calls op on the file at name and returns the response, which the
+// caller must close.
+func (s hdfsSource) get(name, op string) (*http.Response, error) {
+	endpoint, err := s.endpoint(name, op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", op, name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// getJSON calls op on the file at name and decodes the response into v.
+func (s hdfsSource) getJSON(name, op string, v any) error {
+	resp, err := s.get(name, op)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s %s: %v", op, name, err)
+	}
+	return nil
+}
+
+func (s hdfsSource) Stat(name string) (os.FileInfo, error) {
+	var resp struct {
+		FileStatus hdfsFileStatus
+	}
+	if err := s.getJSON(name, "GETFILESTATUS", &resp); err != nil {
+		return nil, err
+	}
+	return hdfsFileInfo{path.Base(name), resp.FileStatus}, nil
+}
+
+func (s hdfsSource) Open(name string) (io.ReadCloser, error) {
+	// the namenode redirects to a datanode holding the data
+	resp, err := s.get(name, "OPEN")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// list returns the entries of the directory at name, ordered by name.
+func (s hdfsSource) list(name string) ([]hdfsFileInfo, error) {
+	var resp struct {
+		FileStatuses struct {
+			FileStatus []hdfsFileStatus
+		}
+	}
+	if err := s.getJSON(name, "LISTSTATUS", &resp); err != nil {
+		return nil, err
+	}
+	var infos []hdfsFileInfo
+	for _, st := range resp.FileStatuses.FileStatus {
+		infos = append(infos, hdfsFileInfo{st.PathSuffix, st})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+	return infos, nil
+}
+
+func (s hdfsSource) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walk(strings.TrimSuffix(root, "/"), info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk walks name like filepath.Walk's walk does.
+func (s hdfsSource) walk(name string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+	entries, err := s.list(name)
+	err1 := fn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, entry := range entries {
+		err := s.walk(name+"/"+entry.name, entry, fn)
+		if err != nil && (!entry.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package md5summer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"os"
+	"strings"
+)
+
+// Keyed digests, set up by -hmac-key-file, are HMACs of the contents of
+// files with a secret key. Someone who changes files can't write the
+// digests to match into the manifest without the key. Their algorithm
+// is named hmac-ALGO, as in "hmac-sha256".
+
+// hmacKey is the key of keyed digests, read from -hmac-key-file.
+var hmacKey []byte
+
+// errNoHMACKey fails keyed digests without a key.
+var errNoHMACKey = errors.New("keyed digests need the key given with -hmac-key-file")
+
+// hmacAlgo returns the algorithm a keyed algorithm is based on.
+func hmacAlgo(algo string) (string, bool) {
+	base, ok := strings.CutPrefix(algo, "hmac-")
+	if !ok || base == "crc32c" || base == "xxhash" {
+		// a checksum, not a hash to build an HMAC on
+		return "", false
+	}
+	_, ok = hashes[base]
+	return base, ok
+}
+
+// newHMAC returns the constructor of the keyed algorithm based on base.
+func newHMAC(base string) func() hash.Hash {
+	return func() hash.Hash { return hmac.New(hashes[base], hmacKey) }
+}
+
+// readHMACKey reads the key of keyed digests from the file name. A line
+// break at the end is not part of the key, so keys written by a text
+// editor or 'openssl rand -hex 32 > FILE' work as well as binary ones.
+func readHMACKey(name string) ([]byte, error) {
+	key, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSuffix(key, []byte("\n"))
+	key = bytes.TrimSuffix(key, []byte("\r"))
+	if len(key) == 0 {
+		return nil, errors.New("the key is empty")
+	}
+	return key, nil
+}
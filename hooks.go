@@ -0,0 +1,151 @@
+package md5summer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Events -exec-on can run commands on.
+const (
+	eventMismatch = "mismatch"
+	eventError    = "error"
+	eventComplete = "complete"
+)
+
+// execHooks maps events to the command templates run when they happen.
+// Templates are split into words at spaces, quotes keeping words with
+// spaces together as in a shell, and run without a shell, so paths
+// substituted for the placeholders in them can't inject commands.
+type execHooks map[string][][]string
+
+// parseExecHooks parses -exec-on values of the form EVENT=COMMAND.
+func parseExecHooks(values []string) (execHooks, error) {
+	hooks := make(execHooks)
+	for _, value := range values {
+		event, command, _ := strings.Cut(value, "=")
+		switch event {
+		case eventMismatch, eventError, eventComplete:
+		default:
+			return nil, fmt.Errorf("unknown event '%s' in '%s', expected mismatch, error or complete", event, value)
+		}
+		words, err := splitWords(command)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the command for event '%s': %v", event, err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("no command given for event '%s'", event)
+		}
+		hooks[event] = append(hooks[event], words)
+	}
+	return hooks, nil
+}
+
+// splitWords splits a command into words at unquoted spaces and tabs.
+// Within single quotes every character is taken as it is, within double
+// quotes a backslash escapes a double quote or a backslash, and outside
+// quotes a backslash escapes any character.
+func splitWords(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	// whether a word was started, so '' gives an empty one
+	inWord := false
+	for ii := 0; ii < len(command); ii++ {
+		c := command[ii]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\'':
+			end := strings.IndexByte(command[ii+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", command)
+			}
+			word.WriteString(command[ii+1 : ii+1+end])
+			ii += end + 1
+		case c == '"':
+			for ii++; ; ii++ {
+				if ii == len(command) {
+					return nil, fmt.Errorf("unterminated double quote in %q", command)
+				}
+				if command[ii] == '"' {
+					break
+				}
+				if command[ii] == '\\' && ii+1 < len(command) && (command[ii+1] == '"' || command[ii+1] == '\\') {
+					ii++
+				}
+				word.WriteByte(command[ii])
+			}
+		case c == '\\':
+			if ii+1 == len(command) {
+				return nil, fmt.Errorf("trailing backslash in %q", command)
+			}
+			ii++
+			word.WriteByte(command[ii])
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// run runs the commands of event one after the other, replacing {name}
+// in their arguments with vars[name]. Their output goes to stderr, which
+// keeps stdout for the report. Failures are logged.
+func (h execHooks) run(event string, vars map[string]string) {
+	var pairs []string
+	for name, value := range vars {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	// a single pass, so placeholders in substituted paths stay as they are
+	replacer := strings.NewReplacer(pairs...)
+	for _, words := range h[event] {
+		args := make([]string, len(words))
+		for ii, word := range words {
+			args[ii] = replacer.Replace(word)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("%s hook %s failed: %v", event, strings.Join(args, " "), err)
+		}
+	}
+}
+
+// verified runs the hooks for the outcome of a verification of the tree
+// at root: mismatch for every file that FAILED or is MISSING, error for
+// every file that could not be checked, and complete once at the end.
+func (h execHooks) verified(results []verification, root string) {
+	for _, v := range results {
+		vars := map[string]string{
+			"path":     v.path,
+			"expected": hex.EncodeToString(v.expected),
+			"actual":   hex.EncodeToString(v.actual),
+			"verdict":  v.verdict,
+			"severity": v.severity,
+		}
+		switch v.verdict {
+		case verdictFailed, verdictMissing:
+			h.run(eventMismatch, vars)
+		case verdictError:
+			h.run(eventError, vars)
+		}
+	}
+	counts := tally(results)
+	h.run(eventComplete, map[string]string{
+		"path":    root,
+		"verdict": fmt.Sprintf("%d OK, %d FAILED, %d MISSING, %d ERROR", counts[verdictOK], counts[verdictFailed], counts[verdictMissing], counts[verdictError]),
+	})
+}
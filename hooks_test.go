@@ -0,0 +1,56 @@
+package md5summer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		command string
+		words   []string
+		fail    bool
+	}{
+		{command: "logger {path}", words: []string{"logger", "{path}"}},
+		{command: "  logger \t {path}  ", words: []string{"logger", "{path}"}},
+		{command: `mail -s 'md5summer: {path} failed' root`, words: []string{"mail", "-s", "md5summer: {path} failed", "root"}},
+		{command: `logger "{path} is \"{verdict}\""`, words: []string{"logger", `{path} is "{verdict}"`}},
+		{command: `echo "a\b" 'c\d' e\ f`, words: []string{"echo", `a\b`, `c\d`, "e f"}},
+		{command: `echo pre'fix 'and" suffix"`, words: []string{"echo", "prefix and suffix"}},
+		{command: `echo '' ""`, words: []string{"echo", "", ""}},
+		{command: "", words: nil},
+		{command: "echo 'open", fail: true},
+		{command: `echo "open`, fail: true},
+		{command: `echo "open\"`, fail: true},
+		{command: `echo \`, fail: true},
+	}
+	for _, test := range tests {
+		words, err := splitWords(test.command)
+		switch {
+		case test.fail && err == nil:
+			t.Errorf("%q: split into %q", test.command, words)
+		case !test.fail && err != nil:
+			t.Errorf("%q: %v", test.command, err)
+		case !test.fail && !slices.Equal(words, test.words):
+			t.Errorf("%q: got %q, want %q", test.command, words, test.words)
+		}
+	}
+}
+
+func TestParseExecHooks(t *testing.T) {
+	hooks, err := parseExecHooks([]string{"mismatch=logger 'a b'", "mismatch=true", "complete=x=y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks[eventMismatch]) != 2 || !slices.Equal(hooks[eventMismatch][0], []string{"logger", "a b"}) {
+		t.Errorf("got mismatch hooks %q", hooks[eventMismatch])
+	}
+	if !slices.Equal(hooks[eventComplete][0], []string{"x=y"}) {
+		t.Errorf("got complete hooks %q", hooks[eventComplete])
+	}
+	for _, value := range []string{"missing=true", "mismatch=", "mismatch", "error='unterminated"} {
+		if _, err := parseExecHooks([]string{value}); err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+}
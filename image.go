@@ -0,0 +1,354 @@
+package md5summer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// partition is a partition of a disk image or block device, as its
+// partition table gives it.
+type partition struct {
+	// number of the partition, as Linux counts them: 1 to 4 for the
+	// primary partitions of an MBR, 5 and up for logical ones
+	number         int
+	offset, length int64
+	sum            []byte
+}
+
+func (p partition) String() string {
+	return fmt.Sprintf("%d 0x%X+0x%X %s", p.number, p.offset, p.length, base64.StdEncoding.EncodeToString(p.sum))
+}
+
+// diskImage is a disk image or block device hashed as a whole by -image,
+// along with its partitions and extents if they were asked for.
+type diskImage struct {
+	path       string
+	partitions []partition
+	// digests of the extents of extentSize bytes, the last one shorter
+	extentSize int64
+	extents    [][]byte
+	size       int64
+}
+
+// writeExtents writes an '#extent OFFSET+SIZE DIGEST IMAGE' line for
+// every extent of the image.
+func (d diskImage) writeExtents(w io.Writer) {
+	for ii, sum := range d.extents {
+		offset := int64(ii) * d.extentSize
+		fmt.Fprintf(w, "%sextent 0x%X+0x%X %s %s\n", headerPrefix, offset, min(d.extentSize, d.size-offset), base64.StdEncoding.EncodeToString(sum), d.path)
+	}
+}
+
+// imageOptions tells hashImage what to calculate besides the digest of
+// the whole image.
+type imageOptions struct {
+	algo       string
+	extraAlgos []string
+	// digests of the partitions in the MBR or GPT
+	partitions bool
+	// if above 0, a digest of every extent of this many bytes
+	extent int64
+	// if set, hashing resumes from the state saved here and saves its
+	// own as it goes
+	states *imageStates
+	// if set, called with the progress of the image every
+	// progressInterval, the totals including what was done before
+	progress func(Progress)
+}
+
+// imageStep is the most an image is read before looking whether the run
+// was aborted.
+const imageStep = 1 << 30
+
+// hashImage calculates the checksum of the disk image or block device
+// at path, and what else opts asks for, all from one read. Block devices
+// report no size, it is found by seeking to their end. If st is aborted,
+// hashing stops with errAborted, saving its state if there are states.
+func hashImage(ctx context.Context, path string, opts imageOptions, st *status) (checksum, diskImage, error) {
+	image := diskImage{path: path, extentSize: opts.extent}
+	file, err := openRead(path)
+	if err != nil {
+		return checksum{}, image, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return checksum{}, image, err
+	}
+	if image.size, err = file.Seek(0, io.SeekEnd); err != nil {
+		return checksum{}, image, err
+	}
+	size := image.size
+
+	h, err := newHash(opts.algo)
+	if err != nil {
+		return checksum{}, image, err
+	}
+	// every hash whose state is saved, in a fixed order
+	all := []hash.Hash{h}
+	var w io.Writer = h
+	var extra *multiHash
+	if len(opts.extraAlgos) > 0 {
+		if extra, err = newMultiHash(opts.extraAlgos); err != nil {
+			return checksum{}, image, err
+		}
+		all = append(all, extra.hashes...)
+		w = io.MultiWriter(w, extra)
+	}
+	var parts *rangeHashes
+	if opts.partitions {
+		if image.partitions, err = readPartitions(file, size); err != nil {
+			return checksum{}, image, fmt.Errorf("cannot read its partition table: %v", err)
+		}
+		if parts, err = newRangeHashes(image.partitions, opts.algo); err != nil {
+			return checksum{}, image, err
+		}
+		all = append(all, parts.hashes...)
+		w = io.MultiWriter(w, parts)
+	}
+	var extent hash.Hash
+	if opts.extent > 0 {
+		if extent, err = newHash(opts.algo); err != nil {
+			return checksum{}, image, err
+		}
+		all = append(all, extent)
+		w = io.MultiWriter(w, extent)
+	}
+
+	offset := int64(0)
+	state := imageState{Size: size, Algo: strings.Join(append([]string{opts.algo}, opts.extraAlgos...), ","), Partitions: opts.partitions, Extent: opts.extent}
+	if opts.states != nil {
+		if offset, image.extents, err = opts.states.resume(path, state, all); err != nil {
+			return checksum{}, image, err
+		}
+		if parts != nil {
+			parts.offset = offset
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return checksum{}, image, err
+	}
+	if opts.progress != nil {
+		defer reportProgress(st, st.files.Load()+1, st.bytes.Load()+size-offset, time.Now, opts.progress)()
+	}
+
+	af := st.start(path, size)
+	af.read.Store(offset)
+	r := progressReader{contextReader{ctx, file}, af, st}
+	saved := time.Now()
+	save := func() error {
+		state.Offset, state.Extents = offset, image.extents
+		saved = time.Now()
+		return opts.states.save(path, state, all)
+	}
+	for offset < size {
+		// stop at the end of every extent, and every imageStep
+		next := min(offset+imageStep, size)
+		if opts.extent > 0 {
+			next = min(next, (offset/opts.extent+1)*opts.extent)
+		}
+		if _, err = io.CopyN(w, r, next-offset); err != nil {
+			break
+		}
+		offset = next
+		if extent != nil && (offset%opts.extent == 0 || offset == size) {
+			image.extents = append(image.extents, extent.Sum(nil))
+			extent.Reset()
+		}
+		// the hashes are at offset only here, a failed read may have
+		// fed them part of a step
+		if opts.states != nil && offset < size && (st.aborted.Load() || time.Since(saved) > imageStateInterval) {
+			if err = save(); err != nil {
+				break
+			}
+		}
+		if st.aborted.Load() && offset < size {
+			err = errAborted
+			break
+		}
+	}
+	st.finish(af, err == nil)
+	if err != nil {
+		return checksum{}, image, err
+	}
+	if opts.states != nil {
+		if err := opts.states.done(path); err != nil {
+			log.Printf("could not remove the state of %s: %v", path, err)
+		}
+	}
+	for ii := range image.partitions {
+		image.partitions[ii].sum = parts.hashes[ii].Sum(nil)
+	}
+	return checksum{path, h.Sum(nil), size, info.ModTime(), opts.algo, extra.digests()}, image, nil
+}
+
+// rangeHashes hashes the ranges of the partitions of what is written to
+// it, which starts at offset 0.
+type rangeHashes struct {
+	partitions []partition
+	hashes     []hash.Hash
+	offset     int64
+}
+
+func newRangeHashes(partitions []partition, algo string) (*rangeHashes, error) {
+	r := &rangeHashes{partitions: partitions}
+	for range partitions {
+		h, err := newHash(algo)
+		if err != nil {
+			return nil, err
+		}
+		r.hashes = append(r.hashes, h)
+	}
+	return r, nil
+}
+
+func (r *rangeHashes) Write(p []byte) (int, error) {
+	end := r.offset + int64(len(p))
+	for ii, part := range r.partitions {
+		from, to := max(part.offset, r.offset), min(part.offset+part.length, end)
+		if from < to {
+			r.hashes[ii].Write(p[from-r.offset : to-r.offset])
+		}
+	}
+	r.offset = end
+	return len(p), nil
+}
+
+// sectorSize is the size of the sectors partition tables count in,
+// unless a GPT is found in 4096-byte sectors.
+const sectorSize = 512
+
+// errNoPartitionTable fails images without an MBR.
+var errNoPartitionTable = errors.New("no MBR or GPT")
+
+// readPartitions reads the partition table of the image of size bytes in
+// r: a GPT, or an MBR with the logical partitions of its extended
+// partition. Partitions reaching beyond the end of the image are cut
+// short.
+func readPartitions(r io.ReaderAt, size int64) ([]partition, error) {
+	mbr := make([]byte, sectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xAA {
+		return nil, errNoPartitionTable
+	}
+	var parts []partition
+	var err error
+	if mbr[446+4] == 0xEE {
+		// a protective MBR, the partitions are in the GPT
+		parts, err = readGPT(r)
+	} else {
+		parts, err = readMBR(r, mbr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for ii := range parts {
+		parts[ii].offset = min(parts[ii].offset, size)
+		parts[ii].length = min(parts[ii].length, size-parts[ii].offset)
+	}
+	return parts, nil
+}
+
+// mbrEntry returns the type, first sector and number of sectors of the
+// ii-th entry of the partition table in the boot sector sector.
+func mbrEntry(sector []byte, ii int) (byte, int64, int64) {
+	e := sector[446+16*ii:]
+	return e[4], int64(binary.LittleEndian.Uint32(e[8:])), int64(binary.LittleEndian.Uint32(e[12:]))
+}
+
+// isExtended tells the partition types that hold logical partitions.
+func isExtended(kind byte) bool {
+	return kind == 0x05 || kind == 0x0F || kind == 0x85
+}
+
+// readMBR returns the partitions of an MBR. Logical partitions are found
+// by following the chain of boot sectors in the extended partition, the
+// first sector of each relative to the extended partition and that of
+// its logical partition relative to the boot sector.
+func readMBR(r io.ReaderAt, mbr []byte) ([]partition, error) {
+	var parts []partition
+	for ii := range 4 {
+		kind, start, count := mbrEntry(mbr, ii)
+		if kind == 0 || count == 0 {
+			continue
+		}
+		if !isExtended(kind) {
+			parts = append(parts, partition{number: ii + 1, offset: start * sectorSize, length: count * sectorSize})
+			continue
+		}
+		ebr := make([]byte, sectorSize)
+		next := int64(0)
+		// a corrupt chain may loop
+		for n := 5; n < 5+128; n++ {
+			if _, err := r.ReadAt(ebr, (start+next)*sectorSize); err != nil {
+				return nil, err
+			}
+			if ebr[510] != 0x55 || ebr[511] != 0xAA {
+				return nil, fmt.Errorf("broken chain of logical partitions at sector %d", start+next)
+			}
+			if _, first, count := mbrEntry(ebr, 0); count > 0 {
+				parts = append(parts, partition{number: n, offset: (start + next + first) * sectorSize, length: count * sectorSize})
+			}
+			kind, first, _ := mbrEntry(ebr, 1)
+			if !isExtended(kind) || first == 0 {
+				break
+			}
+			next = first
+		}
+	}
+	return parts, nil
+}
+
+// gptSignature starts the header of a GPT.
+var gptSignature = []byte("EFI PART")
+
+// readGPT returns the partitions of a GPT, whose header is in the second
+// sector, of 512 or 4096 bytes.
+func readGPT(r io.ReaderAt) ([]partition, error) {
+	header := make([]byte, 92)
+	lba := int64(0)
+	for _, size := range []int64{sectorSize, 4096} {
+		if _, err := r.ReadAt(header, size); err == nil && bytes.HasPrefix(header, gptSignature) {
+			lba = size
+			break
+		}
+	}
+	if lba == 0 {
+		return nil, errors.New("protective MBR without a GPT")
+	}
+	first := int64(binary.LittleEndian.Uint64(header[72:]))
+	count := int64(binary.LittleEndian.Uint32(header[80:]))
+	entrySize := int64(binary.LittleEndian.Uint32(header[84:]))
+	if count > 1024 || entrySize < 128 || entrySize > 4096 {
+		return nil, errors.New("implausible GPT header")
+	}
+	entries := make([]byte, count*entrySize)
+	if _, err := r.ReadAt(entries, first*lba); err != nil {
+		return nil, err
+	}
+	var parts []partition
+	for ii := range count {
+		e := entries[ii*entrySize:]
+		// unused entries have no type
+		if bytes.Equal(e[:16], make([]byte, 16)) {
+			continue
+		}
+		start, last := int64(binary.LittleEndian.Uint64(e[32:])), int64(binary.LittleEndian.Uint64(e[40:]))
+		if start < 0 || last < start {
+			continue
+		}
+		parts = append(parts, partition{number: int(ii) + 1, offset: start * lba, length: (last - start + 1) * lba})
+	}
+	return parts, nil
+}
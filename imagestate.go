@@ -0,0 +1,119 @@
+package md5summer
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// imageStateInterval is how often the state of an image being hashed is
+// saved for -image-state.
+const imageStateInterval = 30 * time.Second
+
+// imageState is how far hashing an image got: the offset and the states
+// of the hashes there, along with what identifies the image and the
+// options, which a resumed run must match.
+type imageState struct {
+	Size       int64    `json:"size"`
+	Algo       string   `json:"algo"`
+	Partitions bool     `json:"partitions"`
+	Extent     int64    `json:"extent"`
+	Offset     int64    `json:"offset"`
+	Hashes     [][]byte `json:"hashes"`
+	Extents    [][]byte `json:"extents"`
+}
+
+// imageStates are the states of the images being hashed, by path, kept
+// in a file for -image-state so a run interrupted after hours can pick up
+// where it stopped.
+type imageStates struct {
+	name   string
+	states map[string]imageState
+}
+
+// loadImageStates reads the states kept in the file name, if it exists.
+func loadImageStates(name string) (*imageStates, error) {
+	s := &imageStates{name: name, states: make(map[string]imageState)}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resume restores hashes to the saved state of the image at path and
+// returns its offset and the digests of the extents before it. A state
+// saved with other options, or for an image of another size, is ignored
+// and the image starts over.
+func (s *imageStates) resume(path string, want imageState, hashes []hash.Hash) (int64, [][]byte, error) {
+	for _, h := range hashes {
+		if _, ok := h.(encoding.BinaryMarshaler); !ok {
+			return 0, nil, fmt.Errorf("the state of %s digests can't be saved", want.Algo)
+		}
+	}
+	saved, ok := s.states[path]
+	if !ok {
+		return 0, nil, nil
+	}
+	if saved.Size != want.Size || saved.Algo != want.Algo || saved.Partitions != want.Partitions || saved.Extent != want.Extent || len(saved.Hashes) != len(hashes) {
+		log.Printf("starting %s over, its state was saved for another size or options", path)
+		return 0, nil, nil
+	}
+	for ii, h := range hashes {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(saved.Hashes[ii]); err != nil {
+			return 0, nil, fmt.Errorf("cannot restore its state: %v", err)
+		}
+	}
+	log.Printf("resuming %s at %s of %s", path, humanBytes(saved.Offset), humanBytes(saved.Size))
+	return saved.Offset, saved.Extents, nil
+}
+
+// save saves state, with the states of hashes, for the image at path.
+func (s *imageStates) save(path string, state imageState, hashes []hash.Hash) error {
+	state.Hashes = nil
+	for _, h := range hashes {
+		b, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		state.Hashes = append(state.Hashes, b)
+	}
+	s.states[path] = state
+	return s.write()
+}
+
+// done forgets the image at path, which was hashed to the end. The file
+// is removed once no image is left in it.
+func (s *imageStates) done(path string) error {
+	if _, ok := s.states[path]; !ok {
+		return nil
+	}
+	delete(s.states, path)
+	if len(s.states) == 0 {
+		return os.Remove(s.name)
+	}
+	return s.write()
+}
+
+func (s *imageStates) write() error {
+	data, err := json.Marshal(s.states)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(s.name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,110 @@
+package md5summer
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// jsonEntry is a file with its expected digests as exported by a backup
+// tool. Keys are matched case-insensitively, so the output of
+// 'rclone lsjson --hash' can be used as is, its directories being
+// skipped:
+//
+//	{"Path": "docs/a.txt", "Hashes": {"md5": "...", "sha256": "..."}}
+type jsonEntry struct {
+	Path   string
+	Hashes map[string]string
+	IsDir  bool
+}
+
+// strongestFirst lists the supported algorithms in the order they are
+// preferred when an entry carries several digests.
+var strongestFirst = []string{"sha512", "sha256", "sha1", "md5"}
+
+// readJSONEntries reads entries from either a JSON array or a stream of
+// JSON objects, one after the other.
+func readJSONEntries(r io.Reader) ([]jsonEntry, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	// peek at the first token to tell an array from a stream
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var entries []jsonEntry
+		return entries, dec.Decode(&entries)
+	}
+	var entries []jsonEntry
+	for {
+		var e jsonEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// verifyJSONList verifies the files listed in the JSON file at list
+// against the tree at root, where relative paths in the list are
+// resolved. Every entry is checked with the strongest algorithm it has a
+// digest for. Results are ordered by path. If stop is set, the
+// verification ends at the first outcome it returns true for, the only
+// one returned then.
+func verifyJSONList(list, root string, stop func(verification) bool) ([]verification, error) {
+	f, err := openRead(list)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := readJSONEntries(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse '%s': %v", list, err)
+	}
+
+	v := newVerifier()
+	v.stop = stop
+	for _, e := range entries {
+		if e.IsDir {
+			// nothing to verify, its files are listed on their own
+			continue
+		}
+		path := e.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, filepath.FromSlash(path))
+		}
+		algo, digest := "", ""
+		for _, a := range strongestFirst {
+			if d, ok := e.Hashes[a]; ok && d != "" {
+				algo, digest = a, d
+				break
+			}
+		}
+		if algo == "" {
+			v.record(verification{path: path, verdict: verdictError, err: fmt.Errorf("no supported digest listed")})
+			continue
+		}
+		sum, err := hex.DecodeString(digest)
+		if err != nil {
+			v.record(verification{path: path, verdict: verdictError, err: fmt.Errorf("malformed %s digest %q", algo, digest)})
+			continue
+		}
+		v.check(path, algo, sum)
+	}
+	return v.wait(), nil
+}
@@ -0,0 +1,39 @@
+package md5summer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lsjson is the output of 'rclone lsjson -R --hash' of a tree with a
+// file in a directory, the MD5 and SHA-1 of "hello\n".
+const lsjson = `[
+{"Path":"docs","Name":"docs","Size":-1,"MimeType":"inode/directory","ModTime":"2026-03-02T10:11:12.000000000+01:00","IsDir":true},
+{"Path":"docs/a.txt","Name":"a.txt","Size":6,"MimeType":"text/plain; charset=utf-8","ModTime":"2026-03-02T10:11:12.000000000+01:00","IsDir":false,"Hashes":{"md5":"b1946ac92492d2347c6235b4d2611184","sha1":"f572d396fae9206628714fb2ce00f72e94f2258f","whirlpool":"","crc32":"363a3020","dropbox":"","quickxor":""}}
+]
+`
+
+func TestVerifyJSONListLsjson(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(t.TempDir(), "list.json")
+	if err := os.WriteFile(list, []byte(lsjson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := verifyJSONList(list, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].verdict != verdictOK || results[0].path != filepath.Join(root, "docs", "a.txt") {
+		t.Errorf("got %v, want docs/a.txt OK alone", results)
+	}
+	if code := verifiedExitCode(results); code != exitIdentical {
+		t.Errorf("exit status %d, want %d", code, exitIdentical)
+	}
+}
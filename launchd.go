@@ -0,0 +1,73 @@
+package md5summer
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeLaunchdPlist writes a launchd job definition that runs md5summer
+// every night at 03:00 with the given arguments. The manifest goes to
+// ~/Library/Logs/<label>.txt and diagnostics next to it.
+func writeLaunchdPlist(w io.Writer, label string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate own executable: %v", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("cannot locate home directory: %v", err)
+	}
+	logs := filepath.Join(home, "Library", "Logs")
+
+	var b strings.Builder
+	str := func(indent, s string) {
+		b.WriteString(indent + "<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>\n")
+	}
+	key := func(k string) {
+		b.WriteString("\t<key>" + k + "</key>\n")
+	}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	key("Label")
+	str("\t", label)
+	key("ProgramArguments")
+	b.WriteString("\t<array>\n")
+	str("\t\t", exe)
+	for _, arg := range args {
+		str("\t\t", arg)
+	}
+	b.WriteString("\t</array>\n")
+	key("StartCalendarInterval")
+	b.WriteString("\t<dict>\n\t\t<key>Hour</key>\n\t\t<integer>3</integer>\n\t\t<key>Minute</key>\n\t\t<integer>0</integer>\n\t</dict>\n")
+	key("StandardOutPath")
+	str("\t", filepath.Join(logs, label+".txt"))
+	key("StandardErrorPath")
+	str("\t", filepath.Join(logs, label+".log"))
+	b.WriteString("</dict>\n</plist>\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// launchdArgs returns the flags of the current invocation that should be
+// passed on to the scheduled job, i.e. all explicitly set flags except
+// the plist generator itself.
+func launchdArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "launchd-plist" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	return args
+}
@@ -0,0 +1,508 @@
+package md5summer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestVersion is bumped whenever the layout of the manifest changes
+// in a way that older readers would misinterpret.
+const manifestVersion = 1
+
+// headerPrefix starts every line of the optional manifest header. Lines
+// starting with '#' are never valid checksum records, so readers that
+// don't understand the header can simply skip them.
+const headerPrefix = "#"
+
+// header describes the run that produced a manifest.
+type header struct {
+	version   int
+	algorithm string
+	root      string
+	flags     []string
+	created   time.Time
+	entries   int
+}
+
+// newHeader builds the header for the current run. Only flags that were
+// explicitly set are recorded, in lexicographical order, so the same
+// invocation always produces the same header apart from the timestamp.
+func newHeader(root, algo string, entries int) header {
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+	return header{
+		version:   manifestVersion,
+		algorithm: algo,
+		root:      root,
+		flags:     flags,
+		created:   time.Now().UTC(),
+		entries:   entries,
+	}
+}
+
+// writeTo writes the header as a block of '#'-prefixed key/value lines.
+func (h header) writeTo(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("md5summer %d", h.version),
+		"algorithm " + h.algorithm,
+		"root " + h.root,
+		"flags " + strings.Join(h.flags, " "),
+		"created " + h.created.Format(time.RFC3339),
+	}
+	// streamed manifests don't know their size up front
+	if h.entries >= 0 {
+		lines = append(lines, fmt.Sprintf("entries %d", h.entries))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, headerPrefix+line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trailer closes a manifest with the number of records and a digest of
+// the record lines, so a truncated or corrupted manifest can be told
+// apart from a tree that lost files.
+type trailer struct {
+	entries int
+	sum     []byte
+}
+
+// writeTo writes the trailer as a single '#'-prefixed line.
+func (t trailer) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%send %d %s\n", headerPrefix, t.entries, base64.StdEncoding.EncodeToString(t.sum))
+	return err
+}
+
+// readManifest reads the records of a manifest written by md5summer, or
+// by GNU md5sum and its siblings, into a map from path to digest, and
+// returns the hash algorithm of the digests. A header written by a newer
+// version or for an unknown algorithm is rejected, and so are manifests
+// mixing algorithms, not matching their trailer, lacking the trailer
+// their header promises or holding another number of records than it
+// says.
+func readManifest(name string) (map[string][]byte, string, error) {
+	sums := make(map[string][]byte)
+	algo, err := scanManifest(name, func(path string, sum []byte) error {
+		sums[path] = sum
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return sums, algo, nil
+}
+
+// scanManifest calls fn for every record of a manifest, in order, and
+// returns the hash algorithm of the digests. It checks the manifest like
+// readManifest does. Lines that aren't records fail the scan unless
+// malformed is set, which is then called for each of them instead.
+func scanManifest(name string, fn func(path string, sum []byte) error, malformed func(line int, err error)) (string, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	algo := ""
+	body := md5.New()
+	records := 0
+	var end *trailer
+	// what the header promises, -1 entries if it doesn't say
+	entries, promised := -1, false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if n == 1 && strings.HasPrefix(line, strings.TrimSuffix(cryptMagic, "\n")) {
+			return "", errors.New("manifest is encrypted, decrypt it first")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, headerPrefix) {
+			key, value, _ := strings.Cut(strings.TrimPrefix(line, headerPrefix), " ")
+			if err := checkHeaderLine(key, value); err != nil {
+				return "", fmt.Errorf("line %d: %v", n, err)
+			}
+			switch key {
+			case "algorithm":
+				algo = value
+			case "entries":
+				if entries, err = strconv.Atoi(value); err != nil || entries < 0 {
+					return "", fmt.Errorf("line %d: malformed entry count '%s'", n, value)
+				}
+			case "flags":
+				promised = promisesTrailer(value)
+			case "end":
+				if end, err = parseTrailer(value); err != nil {
+					return "", fmt.Errorf("line %d: %v", n, err)
+				}
+			}
+			continue
+		}
+		if end != nil {
+			return "", fmt.Errorf("line %d: record after the trailer", n)
+		}
+		path, recordAlgo, sum, err := parseRecord(line, algo)
+		if err != nil {
+			if malformed == nil {
+				return "", err
+			}
+			// such as the rest of a path holding a newline, which the
+			// trailer covers like the record it belongs to
+			fmt.Fprintln(body, line)
+			malformed(n, err)
+			continue
+		}
+		if algo == "" {
+			algo = recordAlgo
+		} else if recordAlgo != algo {
+			return "", fmt.Errorf("line %d: %s digest in a manifest of %s digests", n, recordAlgo, algo)
+		}
+		fmt.Fprintln(body, line)
+		records++
+		if err := fn(path, sum); err != nil {
+			return "", fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if end != nil && (end.entries != records || !bytes.Equal(end.sum, body.Sum(nil))) {
+		return "", errors.New("manifest does not match its trailer, it is truncated or corrupt")
+	}
+	if end == nil && promised {
+		return "", errors.New("manifest ends before its trailer, it is truncated")
+	}
+	if entries >= 0 && entries != records {
+		return "", fmt.Errorf("manifest holds %d records but its header says %d, it is truncated or corrupt", records, entries)
+	}
+	return algo, nil
+}
+
+// promisesTrailer reports whether the flags of a header, as newHeader
+// records them, include -trailer.
+func promisesTrailer(flags string) bool {
+	for _, f := range strings.Fields(flags) {
+		if value, ok := strings.CutPrefix(f, "-trailer="); ok {
+			on, err := strconv.ParseBool(value)
+			return err == nil && on
+		}
+	}
+	return false
+}
+
+// checkHeaderLine rejects header lines that this version can't honor.
+func checkHeaderLine(key, value string) error {
+	switch key {
+	case "md5summer":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("malformed manifest version '%s'", value)
+		}
+		if v > manifestVersion {
+			return fmt.Errorf("manifest version %d is newer than the supported version %d", v, manifestVersion)
+		}
+	case "algorithm":
+		if _, ok := hmacAlgo(value); ok && hmacKey == nil {
+			return errNoHMACKey
+		}
+		if _, ok := hashFunc(value); !ok {
+			return fmt.Errorf("unsupported algorithm '%s'", value)
+		}
+	}
+	return nil
+}
+
+// parseTrailer parses what follows "#end " in a trailer line.
+func parseTrailer(value string) (*trailer, error) {
+	count, digest, _ := strings.Cut(value, " ")
+	entries, err := strconv.Atoi(count)
+	if err != nil {
+		return nil, fmt.Errorf("malformed trailer '%s'", value)
+	}
+	sum, err := base64.StdEncoding.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("malformed trailer '%s'", value)
+	}
+	return &trailer{entries, sum}, nil
+}
+
+// verifyManifest verifies the files listed in a manifest, relative paths
+// being relative to root. Lines of the manifest that aren't records end
+// in verdictError for the manifest. If stop is set, the verification
+// ends at the first outcome it returns true for, the only one returned
+// then.
+func verifyManifest(name, root string, stop func(verification) bool) ([]verification, error) {
+	sums := make(map[string][]byte)
+	var malformed []verification
+	algo, err := scanManifest(name, func(path string, sum []byte) error {
+		sums[path] = sum
+		return nil
+	}, func(n int, err error) {
+		malformed = append(malformed, verification{path: name, verdict: verdictError, err: fmt.Errorf("line %d: %v", n, err)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := newVerifier()
+	v.stop = stop
+	for _, m := range malformed {
+		v.record(m)
+	}
+	for path, sum := range sums {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		v.check(path, algo, sum)
+	}
+	return v.wait(), nil
+}
+
+// parseRecord parses a manifest record as written by checksum.String,
+// or a line of GNU md5sum or one of its siblings, and returns the path,
+// hash algorithm and digest. Bare digests are of the algorithm hint if
+// they are as long as its digests, else told apart by their length.
+func parseRecord(line, hint string) (string, string, []byte, error) {
+	if i := strings.IndexByte(line, ' '); i > 0 && i+1 < len(line) && line[i+1] != ' ' {
+		algo, digest, tagged := strings.Cut(line[:i], ":")
+		if !tagged {
+			digest = algo
+		}
+		if _, ok := hmacAlgo(algo); ok && tagged && hmacKey == nil {
+			return "", "", nil, errNoHMACKey
+		}
+		if sum, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			if !tagged {
+				algo, _ = bareAlgo(sum, hint)
+			}
+			if h, ok := hashFunc(algo); ok && h().Size() == len(sum) {
+				return skipDigests(line[i+1:]), algo, sum, nil
+			}
+		}
+	}
+	sum, name, err := parseGNULine(line)
+	if err != nil {
+		return "", "", nil, err
+	}
+	algo, ok := bareAlgo(sum, hint)
+	if !ok {
+		return "", "", nil, fmt.Errorf("digest of unknown length in %q", line)
+	}
+	return name, algo, sum, nil
+}
+
+// bareAlgo tells the algorithm of a digest without its name, hint if it
+// is as long as its digests, so a header saying blake2b makes digests as
+// long as sha512 ones blake2b.
+func bareAlgo(sum []byte, hint string) (string, bool) {
+	if h, ok := hashFunc(hint); ok && h().Size() == len(sum) {
+		return hint, true
+	}
+	return algoForDigest(sum)
+}
+
+// skipDigests returns the path of a record whose first digest was cut
+// off, skipping the further digests of records with several.
+func skipDigests(rest string) string {
+	for {
+		i := strings.IndexByte(rest, ' ')
+		if i <= 0 || i+1 == len(rest) {
+			return rest
+		}
+		algo, digest, tagged := strings.Cut(rest[:i], ":")
+		h, ok := hashFunc(algo)
+		if !tagged || !ok {
+			return rest
+		}
+		if sum, err := base64.StdEncoding.DecodeString(digest); err != nil || len(sum) != h().Size() {
+			return rest
+		}
+		rest = rest[i+1:]
+	}
+}
+
+// Manifest is a manifest held in memory, for programs that work with
+// manifests rather than trees. It is safe for concurrent use.
+type Manifest struct {
+	mu   sync.RWMutex
+	algo string
+	sums map[string][]byte
+}
+
+// NewManifest returns an empty manifest of algo digests.
+func NewManifest(algo string) *Manifest {
+	return &Manifest{algo: algo, sums: make(map[string][]byte)}
+}
+
+// LoadManifest reads a manifest like readManifest does.
+func LoadManifest(name string) (*Manifest, error) {
+	m := NewManifest("")
+	return m, m.Load(name)
+}
+
+// Load replaces the contents of m with the manifest in the file name.
+func (m *Manifest) Load(name string) error {
+	sums, algo, err := readManifest(name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.algo, m.sums = algo, sums
+	return nil
+}
+
+// Save writes m to the file name, replacing it atomically. Records are
+// ordered by path and followed by a trailer.
+func (m *Manifest) Save(name string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return writeAtomic(name, func(w io.Writer) error {
+		body := md5.New()
+		out := io.MultiWriter(w, body)
+		for _, path := range sortedKeys(m.sums) {
+			cs := checksum{path, m.sums[path], 0, time.Time{}, m.algo, nil}
+			if _, err := fmt.Fprintln(out, cs.String()); err != nil {
+				return err
+			}
+		}
+		return trailer{len(m.sums), body.Sum(nil)}.writeTo(w)
+	})
+}
+
+// Algorithm returns the hash algorithm of the digests, empty if m was
+// never loaded and has no entries.
+func (m *Manifest) Algorithm() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.algo
+}
+
+// Len returns the number of entries.
+func (m *Manifest) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sums)
+}
+
+// Set records the digest of path.
+func (m *Manifest) Set(path string, sum []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sums[path] = sum
+}
+
+// Lookup returns the digest of path.
+func (m *Manifest) Lookup(path string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sum, ok := m.sums[path]
+	return sum, ok
+}
+
+// ByDigest returns the paths with the digest sum, in order.
+func (m *Manifest) ByDigest(sum []byte) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var paths []string
+	for path, s := range m.sums {
+		if bytes.Equal(s, sum) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ManifestDiff lists the paths that differ between two manifests, each
+// in order.
+type ManifestDiff struct {
+	// only in the other manifest
+	Added []string
+	// only in the manifest diffed
+	Removed []string
+	// in both, with different digests
+	Changed []string
+}
+
+// Diff returns how other differs from m. Manifests of different
+// algorithms can't be compared.
+func (m *Manifest) Diff(other *Manifest) (ManifestDiff, error) {
+	otherAlgo, theirs := other.snapshot()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var d ManifestDiff
+	if m.algo != otherAlgo && len(m.sums) > 0 && len(theirs) > 0 {
+		return d, fmt.Errorf("cannot compare %s digests with %s digests", m.algo, otherAlgo)
+	}
+	for _, path := range sortedKeys(m.sums) {
+		if sum, ok := theirs[path]; !ok {
+			d.Removed = append(d.Removed, path)
+		} else if !bytes.Equal(sum, m.sums[path]) {
+			d.Changed = append(d.Changed, path)
+		}
+	}
+	for _, path := range sortedKeys(theirs) {
+		if _, ok := m.sums[path]; !ok {
+			d.Added = append(d.Added, path)
+		}
+	}
+	return d, nil
+}
+
+// Merge adds the entries of other to m, replacing those of the same
+// paths. Manifests of different algorithms can't be merged.
+func (m *Manifest) Merge(other *Manifest) error {
+	otherAlgo, theirs := other.snapshot()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(theirs) == 0 {
+		return nil
+	}
+	if len(m.sums) > 0 && m.algo != otherAlgo {
+		return fmt.Errorf("cannot merge %s digests into %s digests", otherAlgo, m.algo)
+	}
+	m.algo = otherAlgo
+	for path, sum := range theirs {
+		m.sums[path] = sum
+	}
+	return nil
+}
+
+// snapshot returns a copy of the contents of m, so that two manifests
+// are never locked at once.
+func (m *Manifest) snapshot() (string, map[string][]byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make(map[string][]byte, len(m.sums))
+	for path, sum := range m.sums {
+		sums[path] = sum
+	}
+	return m.algo, sums
+}
+
+func sortedKeys(sums map[string][]byte) []string {
+	keys := make([]string, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,177 @@
+package md5summer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGNULineRoundTrip(t *testing.T) {
+	sum := md5.Sum([]byte("data"))
+	names := []string{
+		"plain",
+		"with space",
+		" leading space",
+		"*star",
+		`back\slash`,
+		"new\nline",
+		"carriage\rreturn",
+		"all\\n of\n\\them\r",
+		`\`,
+	}
+	for _, name := range names {
+		for _, mode := range []byte{' ', '*'} {
+			line := gnuModeLine(sum[:], name, mode)
+			if strings.ContainsAny(line, "\n\r") {
+				t.Errorf("%q with mode %q: line %q spans lines", name, mode, line)
+			}
+			got, gotName, err := parseGNULine(line)
+			if err != nil {
+				t.Errorf("%q with mode %q: %v", name, mode, err)
+				continue
+			}
+			if !bytes.Equal(got, sum[:]) || gotName != name {
+				t.Errorf("%q with mode %q: got %x %q back", name, mode, got, gotName)
+			}
+		}
+	}
+}
+
+func TestParseGNULine(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		fail bool
+	}{
+		{line: "d41d8cd98f00b204e9800998ecf8427e  a", name: "a"},
+		{line: "d41d8cd98f00b204e9800998ecf8427e *a", name: "a"},
+		{line: "d41d8cd98f00b204e9800998ecf8427e a", name: "a"},
+		{line: "MD5 (a b) = d41d8cd98f00b204e9800998ecf8427e", name: "a b"},
+		{line: `\d41d8cd98f00b204e9800998ecf8427e  a\nb`, name: "a\nb"},
+		{line: `\d41d8cd98f00b204e9800998ecf8427e  a\tb`, fail: true},
+		{line: `\d41d8cd98f00b204e9800998ecf8427e  a\`, fail: true},
+		{line: "d41d8cd98f00b204e9800998ecf8427  a", fail: true},
+		{line: "d41d8cd98f00b204e9800998ecf8427e", fail: true},
+		{line: "MD5 (a = d41d8cd98f00b204e9800998ecf8427e", fail: true},
+	}
+	for _, test := range tests {
+		_, name, err := parseGNULine(test.line)
+		switch {
+		case test.fail && err == nil:
+			t.Errorf("%q: parsed as %q", test.line, name)
+		case !test.fail && err != nil:
+			t.Errorf("%q: %v", test.line, err)
+		case !test.fail && name != test.name:
+			t.Errorf("%q: got name %q, want %q", test.line, name, test.name)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	md5sum := md5.Sum([]byte("x"))
+	sha := sha256.Sum256([]byte("x"))
+	b2 := newBlake2b().Sum(nil)
+	tests := []struct {
+		line string
+		hint string
+		path string
+		algo string
+		fail bool
+	}{
+		{line: (&checksum{filepath: "a b", sum: md5sum[:], algo: "md5"}).String(), path: "a b", algo: "md5"},
+		{line: (&checksum{filepath: "a", sum: sha[:], algo: "sha256"}).String(), path: "a", algo: "sha256"},
+		// the length tells bare digests apart
+		{line: "4ekrPhcW9ic3OohEUeYYMIgYXBfHnN2KYZfCVm3OA1g= a", path: "a", algo: "sha256"},
+		{line: (&checksum{filepath: "a", sum: sha[:], algo: "sha256", extra: []digest{{"md5", md5sum[:]}}}).String(), path: "a", algo: "sha256"},
+		{line: gnuLine(sha[:], "a"), path: "a", algo: "sha256"},
+		{line: gnuLine(b2[:], "a"), path: "a", algo: "sha512"},
+		{line: gnuLine(b2[:], "a"), hint: "blake2b", path: "a", algo: "blake2b"},
+		{line: gnuLine(sha[:], "a"), hint: "blake2b", path: "a", algo: "sha256"},
+		{line: "hmac-sha256:4ekrPhcW9ic3OohEUeYYMIgYXBfHnN2KYZfCVm3OA1g= a", fail: true},
+		{line: "AAAA a", fail: true},
+		{line: "d41d8cd98f  a", fail: true},
+	}
+	for _, test := range tests {
+		path, algo, _, err := parseRecord(test.line, test.hint)
+		switch {
+		case test.fail && err == nil:
+			t.Errorf("%q: parsed as %s %q", test.line, algo, path)
+		case !test.fail && err != nil:
+			t.Errorf("%q: %v", test.line, err)
+		case !test.fail && (path != test.path || algo != test.algo):
+			t.Errorf("%q: got %s %q, want %s %q", test.line, algo, path, test.algo, test.path)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	md5sum := md5.Sum([]byte("x"))
+	sha := sha256.Sum256([]byte("x"))
+	records := []string{
+		(&checksum{filepath: "a", sum: md5sum[:], algo: "md5"}).String(),
+		(&checksum{filepath: "b", sum: md5sum[:], algo: "md5"}).String(),
+		(&checksum{filepath: "c", sum: md5sum[:], algo: "md5"}).String(),
+	}
+	end := func(records ...string) string {
+		h := md5.New()
+		for _, r := range records {
+			h.Write([]byte(r + "\n"))
+		}
+		var b bytes.Buffer
+		trailer{len(records), h.Sum(nil)}.writeTo(&b)
+		return b.String()
+	}
+	lines := func(lines ...string) string {
+		return strings.Join(lines, "\n") + "\n"
+	}
+	shaRecord := (&checksum{filepath: "d", sum: sha[:], algo: "sha256"}).String()
+	tests := []struct {
+		name     string
+		manifest string
+		// the number of records, or -1 if it must be rejected
+		records int
+	}{
+		{"complete", lines(records...) + end(records...), 3},
+		{"without trailer", lines(records...), 3},
+		{"header", "#md5summer 1\n#algorithm md5\n" + lines(records...), 3},
+		{"header with entries", "#md5summer 1\n#flags -header=true -trailer=true\n#entries 3\n" + lines(records...) + end(records...), 3},
+		{"header without trailer", "#flags -header=true -trailer=false\n" + lines(records...), 3},
+		{"cut before the trailer", "#flags -header=true -trailer=true\n" + lines(records...), -1},
+		{"fewer entries than the header says", "#entries 4\n" + lines(records...), -1},
+		{"more entries than the header says", "#entries 2\n" + lines(records...), -1},
+		{"malformed entries", "#entries three\n" + lines(records...), -1},
+		{"lost record", lines(records[:2]...) + end(records...), -1},
+		{"cut in a record", lines(records[:2]...) + records[2][:len(records[2])-1] + "\n" + end(records...), -1},
+		{"changed record", lines(records[0], records[1], strings.Replace(records[2], " c", " C", 1)) + end(records...), -1},
+		{"record after the trailer", lines(records...) + end(records...) + lines(records[0]), -1},
+		{"cut in the digest", lines(records[:2]...) + records[2][:10] + "\n", -1},
+		{"mixed algorithms", lines(records[0], shaRecord), -1},
+		{"header of another algorithm", "#algorithm sha256\n" + lines(records...), -1},
+		{"newer version", "#md5summer 99\n" + lines(records...), -1},
+		{"unknown algorithm", "#algorithm md4\n" + lines(records...), -1},
+		{"malformed trailer", lines(records...) + "#end three AAAA\n", -1},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		name := filepath.Join(dir, "manifest")
+		if err := os.WriteFile(name, []byte(test.manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sums, algo, err := readManifest(name)
+		if test.records < 0 {
+			if err == nil {
+				t.Errorf("%s: read %d records", test.name, len(sums))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if len(sums) != test.records || algo != "md5" {
+			t.Errorf("%s: got %d %s records, want %d md5", test.name, len(sums), algo, test.records)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x)
+
+package md5summer
+
+import (
+	"encoding/binary"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// startOf returns where the data of the file at path starts: the block
+// LTFS reports for files on tape, else the physical offset of the first
+// extent the filesystem reports through FIEMAP.
+func startOf(path string) (mediaPos, bool) {
+	if pos, ok := ltfsStart(path); ok {
+		return pos, true
+	}
+	return fiemapStart(path)
+}
+
+// ltfsStart reads the extended attributes LTFS gives every file.
+func ltfsStart(path string) (mediaPos, bool) {
+	buf := make([]byte, 32)
+	n, err := syscall.Getxattr(path, "user.ltfs.startblock", buf)
+	if err != nil {
+		return mediaPos{}, false
+	}
+	block, err := strconv.ParseInt(string(buf[:n]), 10, 64)
+	if err != nil {
+		return mediaPos{}, false
+	}
+	pos := mediaPos{offset: block}
+	if n, err := syscall.Getxattr(path, "user.ltfs.partition", buf); err == nil {
+		pos.partition = string(buf[:n])
+	}
+	return pos, true
+}
+
+// fiemapStart asks the filesystem for the first extent of the file.
+func fiemapStart(path string) (mediaPos, bool) {
+	const (
+		fsIocFiemap = 0xC020660B
+		headerSize  = 32
+		extentSize  = 56
+		maxOffset   = ^uint64(0)
+		// the data isn't on the disk yet, e.g. with delayed allocation
+		extentUnknown = 0x2
+	)
+	file, err := openRead(path)
+	if err != nil {
+		return mediaPos{}, false
+	}
+	defer file.Close()
+	// struct fiemap with room for a single struct fiemap_extent
+	var buf [headerSize + extentSize]byte
+	binary.NativeEndian.PutUint64(buf[8:], maxOffset)
+	binary.NativeEndian.PutUint32(buf[24:], 1)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 || binary.NativeEndian.Uint32(buf[20:]) == 0 {
+		// unsupported, or an empty or sparse file
+		return mediaPos{}, false
+	}
+	extent := buf[headerSize:]
+	if binary.NativeEndian.Uint32(extent[40:])&extentUnknown != 0 {
+		return mediaPos{}, false
+	}
+	return mediaPos{offset: int64(binary.NativeEndian.Uint64(extent[8:]))}, true
+}
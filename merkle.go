@@ -0,0 +1,78 @@
+package md5summer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// treeEntry is a file or directory in the directory above it, for the
+// digests of -tree-digests.
+type treeEntry struct {
+	name string
+	dir  bool
+	sum  []byte
+}
+
+// treeDigests calculates a digest for root and every directory below it
+// that holds checksummed files, from the names and digests of what is in
+// it: the digest of the entries sorted by name, each written as 'f' for
+// a file or 'd' for a directory, the name, a NUL byte and the digest of
+// the entry. Equal digests mean equal subtrees, so two trees can be
+// compared from the top down. Directories without checksummed files
+// don't count. The digests are returned as checksums of the directories,
+// their paths ending in a separator, sorted by path.
+func treeDigests(root string, sums []checksum, algo string) ([]checksum, error) {
+	root = filepath.Clean(root)
+	entries := map[string][]treeEntry{root: nil}
+	for _, cs := range sums {
+		dir, name := filepath.Split(cs.filepath)
+		dir = filepath.Clean(dir)
+		entries[dir] = append(entries[dir], treeEntry{name, false, cs.sum})
+		// make sure every directory up to the root gets a digest
+		for dir != root && len(dir) > len(root) {
+			parent := filepath.Dir(dir)
+			if _, ok := entries[parent]; ok {
+				break
+			}
+			entries[parent] = nil
+			dir = parent
+		}
+	}
+
+	// children are done before their parents, being longer
+	dirs := make([]string, 0, len(entries))
+	for dir := range entries {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	digests := make([]checksum, 0, len(dirs))
+	for _, dir := range dirs {
+		h, err := newHash(algo)
+		if err != nil {
+			return nil, err
+		}
+		children := entries[dir]
+		sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+		for _, e := range children {
+			kind := "f"
+			if e.dir {
+				kind = "d"
+			}
+			h.Write([]byte(kind + e.name + "\x00"))
+			h.Write(e.sum)
+		}
+		sum := h.Sum(nil)
+		path := strings.TrimSuffix(dir, string(os.PathSeparator)) + string(os.PathSeparator)
+		digests = append(digests, checksum{path, sum, 0, time.Time{}, algo, nil})
+		if dir != root {
+			parent, name := filepath.Split(dir)
+			parent = filepath.Clean(parent)
+			entries[parent] = append(entries[parent], treeEntry{name, true, sum})
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].filepath < digests[j].filepath })
+	return digests, nil
+}
@@ -0,0 +1,62 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runMonitor implements the monitor subcommand: it checksums every file
+// below a directory as soon as a writer closes it, and prints a record
+// for each until it is interrupted.
+func runMonitor(args []string) {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer monitor DIR")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	root, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(0), err))
+	}
+	err = watchCloseWrite(root, func(path string, f *os.File) {
+		if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return
+		}
+		cs, err := checksumOpenFile(path, f)
+		if err != nil {
+			log.Printf("cannot checksum %s: %v", path, err)
+			return
+		}
+		fmt.Println(cs.String())
+	})
+	if err != nil {
+		panic(fmt.Errorf("cannot monitor '%s': %v", root, err))
+	}
+}
+
+// checksumOpenFile checksums f, which is open at path, from its start.
+func checksumOpenFile(path string, f *os.File) (checksum, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return checksum{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return checksum{}, fmt.Errorf("not a regular file")
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return checksum{}, err
+	}
+	return checksum{path, hash.Sum(nil), info.Size(), info.ModTime(), "md5", nil}, nil
+}
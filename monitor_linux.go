@@ -0,0 +1,72 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+
+package md5summer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// watchCloseWrite calls found with the path and an open descriptor of
+// every file closed after writing on the mount that holds root, using
+// fanotify. Filtering by root is left to found. It needs CAP_SYS_ADMIN,
+// and returns only on errors.
+func watchCloseWrite(root string, found func(path string, f *os.File)) error {
+	const (
+		fanCloexec      = 0x1
+		fanMarkAdd      = 0x1
+		fanMarkMount    = 0x10
+		fanCloseWrite   = 0x8
+		metadataVersion = 3
+		metadataSize    = 24
+	)
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT, fanCloexec, syscall.O_RDONLY|syscall.O_LARGEFILE|syscall.O_CLOEXEC, 0)
+	if errno != 0 {
+		if errno == syscall.EPERM {
+			return errors.New("fanotify needs CAP_SYS_ADMIN, run as root")
+		}
+		return fmt.Errorf("fanotify_init: %v", errno)
+	}
+	events := os.NewFile(fd, "fanotify")
+	defer events.Close()
+
+	path, err := syscall.BytePtrFromString(root)
+	if err != nil {
+		return err
+	}
+	atFdcwd := -100
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, fd, fanMarkAdd|fanMarkMount, fanCloseWrite, uintptr(atFdcwd), uintptr(unsafe.Pointer(path)), 0); errno != 0 {
+		return fmt.Errorf("fanotify_mark: %v", errno)
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := events.Read(buf)
+		if err != nil {
+			return err
+		}
+		// struct fanotify_event_metadata: event_len u32, vers u8,
+		// reserved u8, metadata_len u16, mask u64, fd s32, pid s32
+		for b := buf[:n]; len(b) >= metadataSize; {
+			length := binary.NativeEndian.Uint32(b[0:4])
+			if b[4] != metadataVersion || length < metadataSize || int(length) > len(b) {
+				return errors.New("unexpected fanotify event format")
+			}
+			efd := int32(binary.NativeEndian.Uint32(b[16:20]))
+			b = b[length:]
+			if efd < 0 {
+				// the queue overflowed, those events are lost
+				continue
+			}
+			f := os.NewFile(uintptr(efd), "")
+			if name, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", efd)); err == nil {
+				found(name, f)
+			}
+			f.Close()
+		}
+	}
+}
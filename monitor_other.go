@@ -0,0 +1,13 @@
+//go:build !(linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x))
+
+package md5summer
+
+import (
+	"errors"
+	"os"
+)
+
+// watchCloseWrite is not supported on this platform.
+func watchCloseWrite(root string, found func(path string, f *os.File)) error {
+	return errors.New("monitoring needs fanotify, which is only available on 64-bit Linux")
+}
@@ -0,0 +1,230 @@
+package md5summer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// runMove implements the move subcommand: every file below src is copied
+// to the same relative path below dst and verified, and only then removed
+// from src. Progress is recorded in a journal, so an interrupted move can
+// be resumed by running the same command again.
+func runMove(args []string) {
+	fs := flag.NewFlagSet("move", flag.ExitOnError)
+	journalPath := fs.String("journal", "", "journal recording the progress of the move (default DST.md5summer-journal)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer move [-journal file] SRC DST")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	src, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(0), err))
+	}
+	dst, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", fs.Arg(1), err))
+	}
+	if rel, err := filepath.Rel(src, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		panic(fmt.Errorf("cannot move '%s' into itself", src))
+	}
+	if *journalPath == "" {
+		*journalPath = dst + ".md5summer-journal"
+	}
+
+	j, err := openJournal(*journalPath)
+	if err != nil {
+		panic(fmt.Errorf("cannot open journal '%s': %v", *journalPath, err))
+	}
+	defer j.close()
+
+	opts := walkOptions{process: func(path string, info os.FileInfo, c ctrl) {
+		moveFile(src, dst, path, info, j, c)
+	}}
+	byPath, _ := sortOrder("path", false)
+	checksums, err := walkPath(context.Background(), src, &checksums{less: byPath}, newStatus(), opts)
+	if err != nil {
+		panic(fmt.Errorf("could not move '%s' to '%s': %v", src, dst, err))
+	}
+	removeEmptyDirs(src)
+	for _, cs := range checksums {
+		fmt.Println(cs.String())
+	}
+}
+
+// moveFile moves path, which is below srcRoot, to the same relative path
+// below dstRoot. A file the journal knows was copied and verified before
+// is not copied again, but both the source and the copy must still match
+// the recorded checksum before the source is removed. A copy the journal
+// doesn't know of is kept if it matches the source.
+func moveFile(srcRoot, dstRoot, path string, info os.FileInfo, j *journal, c ctrl) {
+	defer c.wg.Done()
+	defer c.throttle.ready()
+
+	rel, err := filepath.Rel(srcRoot, path)
+	if err != nil {
+		notifyErr(c, err)
+		return
+	}
+	target := filepath.Join(dstRoot, rel)
+
+	sum, ok := j.verified(rel)
+	if ok {
+		for _, p := range []string{path, target} {
+			got, err := sumFile(p)
+			if err != nil {
+				notifyErr(c, err)
+				return
+			}
+			if !bytes.Equal(got, sum) {
+				notifyErr(c, fmt.Errorf("'%s' changed since '%s' was copied to '%s'", p, path, target))
+				return
+			}
+		}
+	} else {
+		// a run that stopped after renaming the copy into place but
+		// before journaling it left a copy that only needs verifying
+		if sum, ok, err = copiedBefore(path, target); err == nil && !ok {
+			sum, err = copyVerified(path, target, info, c)
+		}
+		if err != nil {
+			notifyErr(c, err)
+			return
+		}
+		if err := j.record("verified", rel, sum); err != nil {
+			notifyErr(c, err)
+			return
+		}
+	}
+
+	err = os.Remove(path)
+	accessLog.record("remove", path, 0, err)
+	if err != nil {
+		notifyErr(c, err)
+		return
+	}
+	if err := j.record("removed", rel, sum); err != nil {
+		notifyErr(c, err)
+		return
+	}
+	c.acc.add(checksum{target, sum, info.Size(), info.ModTime(), "md5", nil})
+}
+
+// copiedBefore tells whether target is an existing copy of path the
+// journal doesn't know of, returning its checksum. An existing target
+// with other contents is an error, it is never overwritten.
+func copiedBefore(path, target string) ([]byte, bool, error) {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, false, fmt.Errorf("'%s' already exists", target)
+	}
+	got, err := sumFile(target)
+	if err != nil {
+		return nil, false, err
+	}
+	sum, err := sumFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(got, sum) {
+		return nil, false, fmt.Errorf("'%s' already exists and differs from '%s'", target, path)
+	}
+	return sum, true, nil
+}
+
+// removeEmptyDirs removes the directories below root, and root itself,
+// that are left empty, deepest first.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		// fails for directories that still hold something, which is fine
+		os.Remove(dir)
+	}
+}
+
+// journal is an append-only log of the files a move has copied and
+// removed, one "<state> <checksum> <relative path>" line per step. Every
+// line is flushed to disk before the step it records is built upon.
+type journal struct {
+	lk    sync.Mutex
+	f     *auditedFile
+	state map[string][]byte
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := openFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{f: f, state: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 3)
+		if len(parts) != 3 {
+			// a torn final line from a crash, the step it describes
+			// was never built upon
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		if parts[0] == "verified" {
+			j.state[parts[2]] = sum
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// verified returns the checksum of rel if an earlier run copied and
+// verified it.
+func (j *journal) verified(rel string) ([]byte, bool) {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	sum, ok := j.state[rel]
+	return sum, ok
+}
+
+func (j *journal) record(state, rel string, sum []byte) error {
+	if strings.ContainsAny(rel, "\n") {
+		return fmt.Errorf("cannot journal '%s', it contains a newline", rel)
+	}
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	if _, err := fmt.Fprintf(j.f, "%s %s %s\n", state, base64.StdEncoding.EncodeToString(sum), rel); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) close() error { return j.f.Close() }
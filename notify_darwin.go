@@ -0,0 +1,13 @@
+package md5summer
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// desktopNotify shows a notification through the macOS notification
+// center.
+func desktopNotify(title, message string) error {
+	script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || dragonfly || freebsd || netbsd || openbsd || darwin || windows)
+
+package md5summer
+
+import "errors"
+
+// desktopNotify is not supported on this platform.
+func desktopNotify(title, message string) error {
+	return errors.New("desktop notifications are not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package md5summer
+
+import "os/exec"
+
+// desktopNotify shows a notification through the freedesktop.org
+// notification service, preferring notify-send and falling back to
+// calling the D-Bus interface with gdbus.
+func desktopNotify(title, message string) error {
+	if err := exec.Command("notify-send", "--app-name=md5summer", title, message).Run(); err == nil {
+		return nil
+	}
+	return exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"md5summer", "0", "", title, message, "[]", "{}", "-1").Run()
+}
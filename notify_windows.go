@@ -0,0 +1,26 @@
+package md5summer
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// toastScript raises a toast notification through the WinRT API, which
+// PowerShell can reach without any extra modules.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode('%TITLE%')) > $null
+$text.Item(1).AppendChild($template.CreateTextNode('%MESSAGE%')) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('md5summer').Show($toast)
+`
+
+// desktopNotify shows a toast notification.
+func desktopNotify(title, message string) error {
+	// single quotes are escaped by doubling them in PowerShell strings
+	quote := strings.NewReplacer("'", "''")
+	script := strings.NewReplacer("%TITLE%", quote.Replace(title), "%MESSAGE%", quote.Replace(message)).Replace(toastScript)
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Run()
+}
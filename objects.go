@@ -0,0 +1,279 @@
+package md5summer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// objectSource is a Source for the objects of an S3 or Cloud Storage
+// bucket, with the credentials and endpoint newUploader uses. Paths are
+// s3://bucket/key and gs://bucket/key, the keys below a path with a
+// slash appended are its tree.
+type objectSource struct {
+	scheme string
+	store  *s3Uploader
+	lk     sync.Mutex
+	// objects seen while listing, by path
+	objects map[string]objectInfo
+}
+
+// newObjectSource returns the source for the bucket of dir.
+func newObjectSource(dir string) (*objectSource, error) {
+	u, err := url.Parse(dir)
+	if err != nil {
+		return nil, err
+	}
+	up, err := newUploader(u.Scheme + "://" + u.Host + "/")
+	if err != nil {
+		return nil, err
+	}
+	return &objectSource{scheme: u.Scheme, store: up.(*s3Uploader), objects: make(map[string]objectInfo)}, nil
+}
+
+// objectInfo describes an object, or a directory formed by the keys
+// sharing a prefix.
+type objectInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	dir      bool
+	// as listed, empty if the object was only asked for with HEAD
+	etag string
+}
+
+func (oi objectInfo) Name() string { return oi.name }
+func (oi objectInfo) Size() int64  { return oi.size }
+func (oi objectInfo) Mode() os.FileMode {
+	if oi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (oi objectInfo) ModTime() time.Time { return oi.modified }
+func (oi objectInfo) IsDir() bool        { return oi.dir }
+func (oi objectInfo) Sys() any           { return nil }
+
+// key returns the key of the object at name.
+func (s *objectSource) key(name string) (string, error) {
+	rest, ok := strings.CutPrefix(name, s.scheme+"://"+s.store.bucket)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return "", fmt.Errorf("'%s' is not in %s://%s", name, s.scheme, s.store.bucket)
+	}
+	return strings.TrimPrefix(rest, "/"), nil
+}
+
+// do sends a signed request for key and returns the response of a
+// successful one, which the caller must close.
+func (s *objectSource) do(method, key, query string) (*http.Response, error) {
+	req, err := s.store.request(method, key, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.store.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.store.token)
+	}
+	s.store.sign(req, time.Now().UTC())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: s.scheme + "://" + s.store.bucket + "/" + key, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// list calls fn with the path and description of every object whose key
+// starts with prefix, in the lexicographical order of their keys.
+func (s *objectSource) list(prefix string, limit int, fn func(name string, info objectInfo) error) error {
+	marker := ""
+	for {
+		// keys in the canonical query order the signature needs
+		query := ""
+		if marker != "" {
+			query = "marker=" + uriEncode(marker) + "&"
+		}
+		if limit > 0 {
+			query += fmt.Sprintf("max-keys=%d&", limit)
+		}
+		query += "prefix=" + uriEncode(prefix)
+		resp, err := s.do("GET", "", strings.ReplaceAll(query, "/", "%2F"))
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+				ETag         string
+			}
+			IsTruncated bool
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, c := range result.Contents {
+			marker = c.Key
+			if strings.HasSuffix(c.Key, "/") {
+				// placeholder of an empty folder
+				continue
+			}
+			name := s.scheme + "://" + s.store.bucket + "/" + c.Key
+			if err := fn(name, objectInfo{name: path.Base(c.Key), size: c.Size, modified: c.LastModified, etag: c.ETag}); err != nil {
+				return err
+			}
+		}
+		if limit > 0 || !result.IsTruncated || len(result.Contents) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *objectSource) Stat(name string) (os.FileInfo, error) {
+	s.lk.Lock()
+	info, ok := s.objects[name]
+	s.lk.Unlock()
+	if ok {
+		return info, nil
+	}
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return objectInfo{name: s.store.bucket, dir: true}, nil
+	}
+	// a key is a directory if there are keys below it
+	dir := false
+	err = s.list(strings.TrimSuffix(key, "/")+"/", 1, func(string, objectInfo) error {
+		dir = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dir {
+		return objectInfo{name: path.Base(key), dir: true}, nil
+	}
+	resp, err := s.do("HEAD", key, "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{name: path.Base(key), size: resp.ContentLength, modified: modified}, nil
+}
+
+func (s *objectSource) Open(name string) (io.ReadCloser, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do("GET", key, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Walk calls fn for root and then for every object below it. Buckets
+// have no directories, so fn only ever sees root as one.
+func (s *objectSource) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := s.Stat(root)
+	if err != nil || !info.IsDir() {
+		err = fn(root, info, err)
+	} else if err = fn(root, info, nil); err == nil {
+		key, _ := s.key(root)
+		prefix := ""
+		if key != "" {
+			prefix = strings.TrimSuffix(key, "/") + "/"
+		}
+		err = s.list(prefix, 0, func(name string, info objectInfo) error {
+			s.lk.Lock()
+			s.objects[name] = info
+			s.lk.Unlock()
+			return fn(name, info, nil)
+		})
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// Hash returns the digest the store keeps for the object at name. MD5s
+// are taken from the ETags the listing of Walk returned, other digests
+// and objects not listed are asked for with a HEAD request. Cloud Storage
+// reports the CRC32C of every object and the MD5 of those not composed
+// of others. S3 ETags are the MD5 of objects uploaded in one part and not
+// encrypted with a key of KMS or of the customer, and useless otherwise.
+// A listing doesn't tell how objects are encrypted, so listed objects
+// encrypted with such keys fail to verify rather than pass;
+// -no-trust-remote reads them instead.
+func (s *objectSource) Hash(name, algo string) ([]byte, bool) {
+	if algo != "md5" && algo != "crc32c" {
+		return nil, false
+	}
+	s.lk.Lock()
+	info, listed := s.objects[name]
+	s.lk.Unlock()
+	if listed && algo == "md5" && info.etag != "" {
+		return etagMD5(info.etag)
+	}
+	key, err := s.key(name)
+	if err != nil {
+		return nil, false
+	}
+	resp, err := s.do("HEAD", key, "")
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+
+	if values := resp.Header.Values("X-Goog-Hash"); len(values) > 0 {
+		for _, value := range values {
+			for _, pair := range strings.Split(value, ",") {
+				kind, digest, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if kind == algo {
+					sum, err := base64.StdEncoding.DecodeString(digest)
+					return sum, err == nil
+				}
+			}
+		}
+		return nil, false
+	}
+
+	if algo != "md5" ||
+		strings.HasPrefix(resp.Header.Get("X-Amz-Server-Side-Encryption"), "aws:kms") ||
+		resp.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return nil, false
+	}
+	return etagMD5(resp.Header.Get("ETag"))
+}
+
+// etagMD5 returns the MD5 an ETag holds, which those of objects uploaded
+// in several parts or composed of others don't.
+func etagMD5(etag string) ([]byte, bool) {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	return sum, err == nil && len(sum) == 16
+}
@@ -0,0 +1,93 @@
+package md5summer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// atomicOutput is the manifest file of -output. It is written to a
+// temporary file in the same directory, which replaces the file only
+// once the manifest is complete, so a crash never leaves a half-written
+// manifest behind.
+type atomicOutput struct {
+	*auditedFile
+	path string
+	done bool
+}
+
+// createOutput starts writing the manifest file path. With appendTo, the
+// temporary file starts out as a copy of the existing manifest, if any.
+func createOutput(path string, appendTo bool) (*atomicOutput, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		accessLog.record("create", path+".tmp*", 0, err)
+		return nil, err
+	}
+	accessLog.record("create", f.Name(), 0, nil)
+	o := &atomicOutput{auditedFile: &auditedFile{File: f}, path: path}
+	if appendTo {
+		if err := o.copyFrom(path); err != nil {
+			o.abort()
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// copyFrom copies the manifest in the file path, making sure it ends
+// with a newline. A missing file is an empty manifest.
+func (o *atomicOutput) copyFrom(path string) error {
+	existing, err := openRead(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer existing.Close()
+	n, err := io.Copy(o, existing)
+	if err != nil || n == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := existing.ReadAt(last, n-1); err != nil {
+		return err
+	}
+	if !bytes.Equal(last, []byte("\n")) {
+		_, err = io.WriteString(o, "\n")
+	}
+	return err
+}
+
+// commit flushes the manifest to disk and renames it into place.
+func (o *atomicOutput) commit() error {
+	o.done = true
+	err := o.Sync()
+	if err == nil {
+		err = o.Chmod(0644)
+	}
+	if cerr := o.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(o.Name(), o.path)
+		accessLog.record("rename", o.path, 0, err)
+	}
+	if err != nil {
+		os.Remove(o.Name())
+	}
+	return err
+}
+
+// abort throws away the manifest unless it was committed, the file it
+// was to replace is left as it was.
+func (o *atomicOutput) abort() {
+	if o.done {
+		return
+	}
+	o.done = true
+	o.Close()
+	os.Remove(o.Name())
+}
@@ -0,0 +1,69 @@
+package md5summer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// writePerDirManifests writes a manifest called name into every directory
+// that holds at least one of sums, covering the files directly inside it
+// in the format of GNU md5sum. Each manifest is written to a temporary
+// file and renamed into place, so readers never see a partial one.
+func writePerDirManifests(name string, sums []checksum) error {
+	byDir := make(map[string][]checksum)
+	for _, cs := range sums {
+		dir := filepath.Dir(cs.filepath)
+		byDir[dir] = append(byDir[dir], cs)
+	}
+	for dir, files := range byDir {
+		sort.Slice(files, func(i, j int) bool { return files[i].filepath < files[j].filepath })
+		if err := writeDirManifest(filepath.Join(dir, name), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDirManifest(path string, files []checksum) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		for _, cs := range files {
+			if _, err := fmt.Fprintln(w, gnuLine(cs.sum, filepath.Base(cs.filepath))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeAtomic creates the file at path with the contents written by
+// write, through a temporary file in the same directory that is renamed
+// into place once complete.
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		accessLog.record("create", path+".tmp*", 0, err)
+		return err
+	}
+	accessLog.record("create", f.Name(), 0, nil)
+	tmp := &auditedFile{File: f}
+	// a no-op once the rename succeeded
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	accessLog.record("rename", path, 0, err)
+	return err
+}
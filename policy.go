@@ -0,0 +1,152 @@
+package md5summer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Severities a policy assigns to the outcome of verifying a file. Results
+// that are ignored are dropped, informational ones are reported but
+// don't change the exit status.
+const (
+	severityIgnore   = "ignore"
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// policyRule assigns a severity, and optionally an alert, to the files
+// matching a pattern.
+type policyRule struct {
+	pattern  string
+	severity string
+	alert    bool
+}
+
+// matches reports whether the slash-separated path rel, relative to the
+// root, matches the rule. Patterns without a slash match any component
+// of the path, others match the path or any of its leading directories,
+// so 'archive' and 'archive/*' both cover everything below archive/.
+func (r policyRule) matches(rel string) bool {
+	parts := strings.Split(rel, "/")
+	if !strings.Contains(r.pattern, "/") {
+		for _, part := range parts {
+			if ok, _ := path.Match(r.pattern, part); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for ii := range parts {
+		if ok, _ := path.Match(r.pattern, strings.Join(parts[:ii+1], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// policy is an ordered list of rules, the first one matching a file
+// applies. Files no rule matches are warnings.
+type policy []policyRule
+
+// readPolicy reads a policy file with one "PATTERN SEVERITY [alert]" rule
+// per line, for example:
+//
+//	# mismatches in the archive page someone, scratch space doesn't matter
+//	archive   critical alert
+//	tmp       ignore
+//	*.log     info
+//
+// Blank lines and lines starting with '#' are ignored.
+func readPolicy(name string) (policy, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p policy
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "alert") {
+			return nil, fmt.Errorf("line %d: expected PATTERN SEVERITY [alert]", n)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern '%s': %v", n, fields[0], err)
+		}
+		switch fields[1] {
+		case severityIgnore, severityInfo, severityWarning, severityCritical:
+		default:
+			return nil, fmt.Errorf("line %d: unknown severity '%s', expected ignore, info, warning or critical", n, fields[1])
+		}
+		p = append(p, policyRule{fields[0], fields[1], len(fields) == 3})
+	}
+	return p, scanner.Err()
+}
+
+// rule returns the rule that applies to path below root.
+func (p policy) rule(root, filePath string) policyRule {
+	rel, err := filepath.Rel(root, filePath)
+	if err == nil {
+		rel = filepath.ToSlash(rel)
+		for _, r := range p {
+			if r.matches(rel) {
+				return r
+			}
+		}
+	}
+	return policyRule{severity: severityWarning}
+}
+
+// notifier raises a desktop notification with what is written to it as
+// the message.
+type notifier struct {
+	title string
+}
+
+func (n notifier) Write(p []byte) (int, error) {
+	if err := desktopNotify(n.title, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// apply assigns a severity to every result that isn't OK, drops the
+// ignored ones and raises the alerts. It returns the results left.
+func (p policy) apply(results []verification, root string) []verification {
+	kept := results[:0]
+	ignored := 0
+	for _, v := range results {
+		if v.verdict == verdictOK {
+			kept = append(kept, v)
+			continue
+		}
+		r := p.rule(root, v.path)
+		if r.severity == severityIgnore {
+			ignored++
+			continue
+		}
+		v.severity = r.severity
+		addNote(&v, "severity "+r.severity)
+		if r.alert {
+			alert := redactOutput(notifier{title: "md5summer: " + v.verdict})
+			if _, err := io.WriteString(alert, v.path); err != nil {
+				log.Printf("could not raise alert for %s: %v", v.path, err)
+			}
+		}
+		kept = append(kept, v)
+	}
+	if ignored > 0 {
+		log.Printf("ignored %d results as the policy says", ignored)
+	}
+	return kept
+}
@@ -0,0 +1,177 @@
+package md5summer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// progressInterval is how often the progress hook of a walk is called.
+const progressInterval = 500 * time.Millisecond
+
+// Progress is a snapshot of a running walk, as passed to Options.Progress
+// and the progress hook of walkOptions, or of a verification, as passed
+// to VerifyOptions.Progress.
+type Progress struct {
+	// files and bytes done so far, including files whose digest was
+	// known without reading them
+	Files, Bytes int64
+	// what the walk is going to checksum, 0 if it wasn't scanned first
+	TotalFiles, TotalBytes int64
+	Elapsed                time.Duration
+	// set for the last call, once the walk is over
+	Done bool
+}
+
+// ETA estimates the time left from the throughput so far, 0 if it can't
+// tell.
+func (p Progress) ETA() time.Duration {
+	if p.TotalBytes == 0 || p.Bytes == 0 || p.Bytes >= p.TotalBytes {
+		return 0
+	}
+	rate := float64(p.Bytes) / p.Elapsed.Seconds()
+	return time.Duration(float64(p.TotalBytes-p.Bytes) / rate * float64(time.Second))
+}
+
+// scanTotals counts the files a walk of root with opts would checksum,
+// and their bytes.
+func scanTotals(src Source, root string, opts walkOptions) (files, bytes int64) {
+	visitFiles(src, root, opts, func(path string, info os.FileInfo) {
+		files++
+		bytes += info.Size()
+	})
+	return files, bytes
+}
+
+// visitFiles calls fn for every file a walk of root with opts would
+// checksum, without reading any. Whatever can't be read is left out, the
+// walk itself reports it.
+func visitFiles(src Source, root string, opts walkOptions, fn func(path string, info os.FileInfo)) {
+	relative := func(path string) string {
+		if opts.keepFile == nil && opts.keepDir == nil {
+			return ""
+		}
+		rel, _ := filepath.Rel(root, path)
+		return filepath.ToSlash(rel)
+	}
+	visitFile := func(path string, info os.FileInfo) {
+		for _, name := range opts.excludeNames {
+			if info.Name() == name {
+				return
+			}
+		}
+		if opts.keepFile != nil && !opts.keepFile(relative(path), info) {
+			return
+		}
+		fn(path, info)
+	}
+	if opts.listOnly {
+		seen := make(map[string]bool)
+		for _, path := range opts.first {
+			path, ok := belowRoot(root, path)
+			if !ok || seen[path] {
+				continue
+			}
+			seen[path] = true
+			if info, err := src.Stat(path); err == nil && info.Mode().IsRegular() {
+				visitFile(path, info)
+			}
+		}
+		return
+	}
+	src.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != root && opts.keepDir != nil && !opts.keepDir(relative(path), info) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		visitFile(path, info)
+		return nil
+	})
+}
+
+// reportProgress calls fn with the progress of the walk followed by st
+// every progressInterval, until the returned function is called, which
+// calls it a last time. The time elapsed is measured by now.
+func reportProgress(st *status, totalFiles, totalBytes int64, now func() time.Time, fn func(Progress)) func() {
+	snapshot := func(done bool) Progress {
+		return Progress{st.files.Load(), st.bytes.Load(), totalFiles, totalBytes, now().Sub(st.started), done}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn(snapshot(false))
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		fn(snapshot(true))
+	}
+}
+
+// progressBar renders the progress of a walk for -progress: on a
+// terminal as a single line redrawn in place, elsewhere as a line every
+// few seconds.
+type progressBar struct {
+	w       io.Writer
+	tty     bool
+	printed time.Time
+}
+
+// progressBarWidth is the number of characters of the bar itself.
+const progressBarWidth = 30
+
+func newProgressBar(f *os.File) *progressBar {
+	info, err := f.Stat()
+	return &progressBar{w: f, tty: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *progressBar) update(p Progress) {
+	if !b.tty && !p.Done && time.Since(b.printed) < 5*time.Second {
+		return
+	}
+	b.printed = time.Now()
+
+	var fraction float64
+	switch {
+	case p.TotalBytes > 0:
+		fraction = float64(p.Bytes) / float64(p.TotalBytes)
+	case p.TotalFiles > 0:
+		fraction = float64(p.Files) / float64(p.TotalFiles)
+	}
+	fraction = min(fraction, 1)
+	filled := int(fraction * progressBarWidth)
+	line := fmt.Sprintf("[%s%s] %3.0f%% %d/%d files %s/%s %s/s",
+		strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), fraction*100,
+		p.Files, p.TotalFiles, humanBytes(p.Bytes), humanBytes(p.TotalBytes),
+		humanBytes(int64(float64(p.Bytes)/max(p.Elapsed.Seconds(), 0.001))))
+	if eta := p.ETA(); eta > 0 {
+		line += " ETA " + eta.Round(time.Second).String()
+	}
+	if b.tty {
+		// clear what is left of a longer line before
+		fmt.Fprint(b.w, "\r"+line+"\x1b[K")
+		if p.Done {
+			fmt.Fprintln(b.w)
+		}
+		return
+	}
+	fmt.Fprintln(b.w, line)
+}
@@ -0,0 +1,100 @@
+package md5summer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mismatchAction is what -on-mismatch does to files that fail
+// verification, so they stop being served before anyone looks at them.
+type mismatchAction struct {
+	// directory failed files are moved to, keeping their path below root
+	quarantine string
+	// suffix appended to the names of failed files
+	suffix string
+}
+
+// parseMismatchAction parses quarantine=/path or rename-suffix=.corrupt.
+func parseMismatchAction(value string) (*mismatchAction, error) {
+	kind, arg, ok := strings.Cut(value, "=")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("expected quarantine=DIR or rename-suffix=SUFFIX, got '%s'", value)
+	}
+	switch kind {
+	case "quarantine":
+		dir, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &mismatchAction{quarantine: dir}, nil
+	case "rename-suffix":
+		if strings.ContainsRune(arg, filepath.Separator) {
+			return nil, fmt.Errorf("suffix '%s' must not contain a path separator", arg)
+		}
+		return &mismatchAction{suffix: arg}, nil
+	}
+	return nil, fmt.Errorf("unknown action '%s', expected quarantine or rename-suffix", kind)
+}
+
+// target returns where the failed file at path below root goes.
+func (a *mismatchAction) target(root, path string) (string, error) {
+	if a.suffix != "" {
+		return path + a.suffix, nil
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is not below '%s'", path, root)
+	}
+	return filepath.Join(a.quarantine, rel), nil
+}
+
+// apply moves every file that failed verification out of the way, noting
+// where it went. With dryRun it only notes where it would go. Existing
+// files are never overwritten, and failures to move are logged and leave
+// the file in place.
+func (a *mismatchAction) apply(results []verification, root string, dryRun bool) {
+	for ii, v := range results {
+		if v.verdict != verdictFailed {
+			continue
+		}
+		target, err := a.target(root, v.path)
+		if err == nil {
+			if dryRun {
+				addNote(&results[ii], "would be moved to "+target)
+				continue
+			}
+			err = moveAside(v.path, target)
+		}
+		if err != nil {
+			log.Printf("could not move %s aside: %v", v.path, err)
+			continue
+		}
+		addNote(&results[ii], "moved to "+target)
+	}
+}
+
+// moveAside renames path to target, creating the directories leading to
+// target. Renaming does not cross filesystems, so the quarantine must be
+// on the same one as the files.
+func moveAside(path, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("'%s' already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	err := os.Rename(path, target)
+	accessLog.record("rename", path, 0, err)
+	return err
+}
+
+// addNote appends note to the notes of v.
+func addNote(v *verification, note string) {
+	if v.note != "" {
+		v.note += "; "
+	}
+	v.note += note
+}
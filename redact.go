@@ -0,0 +1,93 @@
+package md5summer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// redaction, if set, is applied to every path shown in reports and logs
+// by writing them through redactOutput. Manifests always carry the full
+// paths. It is set up by -redact-pattern.
+var redaction *redactor
+
+// redactor replaces the path components matching any of its patterns.
+type redactor struct {
+	patterns []*regexp.Regexp
+	// replace matches with a fixed marker instead of a hash of the
+	// component, which would let readers tell equal components apart
+	mask bool
+}
+
+// isComponentSeparator reports whether c ends a path component in free
+// text: path separators, whitespace and the punctuation used around
+// paths in messages.
+func isComponentSeparator(c rune) bool {
+	return strings.ContainsRune("/\\ \t\r\n\"'(),:;", c)
+}
+
+// redact replaces every path component in s that a pattern matches.
+func (r *redactor) redact(s string) string {
+	var b strings.Builder
+	start := 0
+	flush := func(end int) {
+		component := s[start:end]
+		b.WriteString(r.replace(component))
+	}
+	for ii, c := range s {
+		if isComponentSeparator(c) {
+			flush(ii)
+			b.WriteRune(c)
+			start = ii + len(string(c))
+		}
+	}
+	flush(len(s))
+	return b.String()
+}
+
+func (r *redactor) replace(component string) string {
+	if component == "" {
+		return component
+	}
+	for _, p := range r.patterns {
+		if p.MatchString(component) {
+			if r.mask {
+				return "[redacted]"
+			}
+			sum := sha256.Sum256([]byte(component))
+			return "[redacted-" + hex.EncodeToString(sum[:6]) + "]"
+		}
+	}
+	return component
+}
+
+// redactOutput returns w redacting everything written through it, or w
+// itself if redaction is disabled.
+func redactOutput(w io.Writer) io.Writer {
+	if redaction == nil {
+		return w
+	}
+	return redactingWriter{w: w, r: redaction}
+}
+
+// redactingWriter redacts everything written through it. Each write must
+// be complete lines, as the log package guarantees.
+type redactingWriter struct {
+	w io.Writer
+	r *redactor
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// patternList collects the values of a repeatable flag.
+type patternList []string
+
+func (l *patternList) String() string     { return strings.Join(*l, ", ") }
+func (l *patternList) Set(v string) error { *l = append(*l, v); return nil }
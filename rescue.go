@@ -0,0 +1,205 @@
+package md5summer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rescueFinished is the status ddrescue gives to blocks it read.
+const rescueFinished = '+'
+
+// rescueStatuses names the statuses of blocks in a ddrescue map.
+var rescueStatuses = map[byte]string{
+	'?':            "non-tried",
+	'*':            "non-trimmed",
+	'/':            "non-scraped",
+	'-':            "bad-sector",
+	rescueFinished: "finished",
+}
+
+// rescueBlock is a range of an image and how far ddrescue got with it.
+type rescueBlock struct {
+	pos, size int64
+	status    byte
+}
+
+func (b rescueBlock) String() string {
+	return fmt.Sprintf("0x%X+0x%X %s", b.pos, b.size, rescueStatuses[b.status])
+}
+
+// rescueMaps maps the absolute paths of images recovered by ddrescue to
+// the blocks of their map files.
+type rescueMaps map[string][]rescueBlock
+
+// parseRescueMaps parses -ddrescue-map values of the form IMAGE=MAPFILE,
+// relative images being below root.
+func parseRescueMaps(values []string, root string) (rescueMaps, error) {
+	maps := make(rescueMaps)
+	for _, value := range values {
+		image, name, ok := strings.Cut(value, "=")
+		if !ok || image == "" || name == "" {
+			return nil, fmt.Errorf("expected IMAGE=MAPFILE, got '%s'", value)
+		}
+		blocks, err := readRescueMap(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read '%s': %v", name, err)
+		}
+		if !filepath.IsAbs(image) {
+			image = filepath.Join(root, image)
+		}
+		maps[filepath.Clean(image)] = blocks
+	}
+	return maps, nil
+}
+
+// readRescueMap reads the blocks of a ddrescue map file. After comments
+// starting with '#' comes the line with the current position and status,
+// then a line of position, size and status per block, the numbers in
+// hexadecimal or decimal.
+func readRescueMap(name string) ([]rescueBlock, error) {
+	file, err := openRead(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var blocks []rescueBlock
+	current := false
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !current {
+			// the position ddrescue was at, not a block
+			current = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || len(fields[2]) != 1 {
+			return nil, fmt.Errorf("line %d: expected position, size and status", n)
+		}
+		var b rescueBlock
+		if b.pos, err = strconv.ParseInt(fields[0], 0, 64); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if b.size, err = strconv.ParseInt(fields[1], 0, 64); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		b.status = fields[2][0]
+		if _, ok := rescueStatuses[b.status]; !ok {
+			return nil, fmt.Errorf("line %d: unknown status '%c'", n, b.status)
+		}
+		blocks = append(blocks, b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !current {
+		return nil, fmt.Errorf("not a ddrescue map")
+	}
+	return blocks, nil
+}
+
+// unrecovered returns the blocks that ddrescue didn't read and the
+// number of bytes in them.
+func unrecovered(blocks []rescueBlock) ([]rescueBlock, int64) {
+	var bad []rescueBlock
+	var bytes int64
+	for _, b := range blocks {
+		if b.status != rescueFinished {
+			bad = append(bad, b)
+			bytes += b.size
+		}
+	}
+	return bad, bytes
+}
+
+// paths returns the images, sorted.
+func (m rescueMaps) paths() []string {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// annotate notes on the verifications of images which parts of them are
+// unrecovered, as a match of their digest doesn't vouch for those.
+func (m rescueMaps) annotate(results []verification) {
+	for ii, v := range results {
+		bad, bytes := unrecovered(m[filepath.Clean(v.path)])
+		if len(bad) == 0 {
+			continue
+		}
+		note := fmt.Sprintf("%d unrecovered regions of %s per its ddrescue map", len(bad), humanBytes(bytes))
+		if results[ii].note != "" {
+			note = results[ii].note + "; " + note
+		}
+		results[ii].note = note
+	}
+}
+
+// writePieces writes to w, for every image, a '#piece' line with the
+// digest of each block ddrescue read and an '#unrecovered' line for each
+// block it didn't, so that the parts known to be good can be verified on
+// their own. The images are read once more for this. Images with
+// unrecovered blocks are logged, and so are images that can't be read.
+func (m rescueMaps) writePieces(w io.Writer, algo string, comments bool) {
+	for _, path := range m.paths() {
+		blocks := m[path]
+		if bad, bytes := unrecovered(blocks); len(bad) > 0 {
+			log.Printf("%s has %d unrecovered regions of %s per its ddrescue map; its digest covers whatever they hold", path, len(bad), humanBytes(bytes))
+		}
+		if !comments {
+			continue
+		}
+		file, err := openRead(path)
+		if err != nil {
+			log.Printf("could not hash the pieces of %s: %v", path, err)
+			continue
+		}
+		for _, b := range blocks {
+			if b.status != rescueFinished {
+				fmt.Fprintf(w, "%sunrecovered %s %s\n", headerPrefix, b, path)
+				continue
+			}
+			sum, err := pieceDigest(file, b, algo)
+			if err != nil {
+				log.Printf("could not hash %s of %s: %v", b, path, err)
+				break
+			}
+			fmt.Fprintf(w, "%spiece 0x%X+0x%X %s %s\n", headerPrefix, b.pos, b.size, base64.StdEncoding.EncodeToString(sum), path)
+		}
+		file.Close()
+	}
+}
+
+// pieceDigest returns the digest of the block b of file, read through
+// file so that the access log counts it.
+func pieceDigest(file io.ReadSeeker, b rescueBlock, algo string) ([]byte, error) {
+	hash, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(b.pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	n, err := io.CopyN(hash, file, b.size)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n != b.size {
+		return nil, fmt.Errorf("the image ends %d bytes early", b.size-n)
+	}
+	return hash.Sum(nil), nil
+}
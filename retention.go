@@ -0,0 +1,82 @@
+package md5summer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runPrefix and runTimeLayout make up the names of uploaded runs,
+// e.g. md5summer-20240131T030000Z.txt.
+const (
+	runPrefix     = "md5summer-"
+	runTimeLayout = "20060102T150405Z"
+)
+
+// runName returns the base name of the objects of a run that finished at t.
+func runName(t time.Time) string {
+	return runPrefix + t.UTC().Format(runTimeLayout)
+}
+
+// parseRunName returns the time of the run an object belongs to.
+func parseRunName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, runPrefix) || len(name) < len(runPrefix)+len(runTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(runTimeLayout, name[len(runPrefix):len(runPrefix)+len(runTimeLayout)])
+	return t, err == nil
+}
+
+// expiredRuns returns the runs a retention policy of keep runs lets go of.
+// The newest keep runs are retained, and so is the oldest run of every
+// month, as an anchor to compare against long after the fact.
+func expiredRuns(runs []time.Time, keep int) []time.Time {
+	sorted := make([]time.Time, len(runs))
+	copy(sorted, runs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	anchors := make(map[string]bool)
+	var expired []time.Time
+	for ii, t := range sorted {
+		month := t.Format("2006-01")
+		if !anchors[month] {
+			anchors[month] = true
+			continue
+		}
+		if ii >= len(sorted)-keep {
+			continue
+		}
+		expired = append(expired, t)
+	}
+	return expired
+}
+
+// applyRetention removes the objects of the runs at dest that have
+// expired under a policy of keeping keep runs.
+func applyRetention(up uploader, keep int) error {
+	names, err := up.list()
+	if err != nil {
+		return err
+	}
+	byRun := make(map[time.Time][]string)
+	var runs []time.Time
+	for _, name := range names {
+		t, ok := parseRunName(name)
+		if !ok {
+			continue
+		}
+		if _, seen := byRun[t]; !seen {
+			runs = append(runs, t)
+		}
+		byRun[t] = append(byRun[t], name)
+	}
+	for _, t := range expiredRuns(runs, keep) {
+		for _, name := range byRun[t] {
+			if err := up.remove(name); err != nil {
+				return fmt.Errorf("cannot remove '%s': %v", name, err)
+			}
+		}
+	}
+	return nil
+}
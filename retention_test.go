@@ -0,0 +1,72 @@
+package md5summer
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExpiredRuns(t *testing.T) {
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2024, month, day, 3, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		runs    []time.Time
+		keep    int
+		expired []time.Time
+	}{
+		{
+			name:    "newest kept",
+			runs:    []time.Time{day(1, 1), day(1, 5), day(1, 10), day(1, 20)},
+			keep:    2,
+			expired: []time.Time{day(1, 5)},
+		},
+		{
+			name:    "first of every month kept",
+			runs:    []time.Time{day(1, 1), day(1, 15), day(2, 1), day(2, 2), day(2, 3)},
+			keep:    1,
+			expired: []time.Time{day(1, 15), day(2, 2)},
+		},
+		{
+			name:    "unsorted",
+			runs:    []time.Time{day(2, 3), day(1, 15), day(2, 1), day(1, 1), day(2, 2)},
+			keep:    1,
+			expired: []time.Time{day(1, 15), day(2, 2)},
+		},
+		{
+			name: "fewer than keep",
+			runs: []time.Time{day(1, 1), day(1, 2)},
+			keep: 5,
+		},
+		{
+			name: "anchors among the newest",
+			runs: []time.Time{day(1, 1), day(2, 1), day(3, 1)},
+			keep: 1,
+		},
+		{
+			name: "none",
+			keep: 3,
+		},
+	}
+	for _, test := range tests {
+		got := expiredRuns(test.runs, test.keep)
+		if !slices.EqualFunc(got, test.expired, time.Time.Equal) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.expired)
+		}
+	}
+}
+
+func TestRunName(t *testing.T) {
+	finished := time.Date(2024, 1, 31, 3, 0, 0, 0, time.UTC)
+	for _, name := range []string{runName(finished) + ".txt", runName(finished) + ".summary.json"} {
+		if got, ok := parseRunName(name); !ok || !got.Equal(finished) {
+			t.Errorf("%s: got %v, %v", name, got, ok)
+		}
+	}
+	for _, name := range []string{"md5summer-2024.txt", "other-20240131T030000Z.txt", "md5summer-20241331T030000Z.txt"} {
+		if _, ok := parseRunName(name); ok {
+			t.Errorf("%s was taken for a run", name)
+		}
+	}
+}
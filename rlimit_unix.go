@@ -0,0 +1,16 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package md5summer
+
+import "syscall"
+
+// openFileLimit returns the number of files the process may have open at
+// a time. The Go runtime has already raised the soft limit to the hard
+// one at startup, so this is as high as it gets without privileges.
+func openFileLimit() (uint64, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	return uint64(rl.Cur), true
+}
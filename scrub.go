@@ -0,0 +1,91 @@
+package md5summer
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// correlateFSErrors annotates failed verifications with what ZFS and
+// Btrfs know about the tree at root, to tell files the filesystem itself
+// reports as corrupt apart from files that were changed through it.
+// Tools that are missing or fail are skipped with a diagnostic.
+func correlateFSErrors(results []verification, root string) {
+	zfs, err := zfsErrorPaths()
+	if err != nil {
+		log.Printf("not correlating with ZFS: %v", err)
+	}
+	device := false
+	if counters, err := btrfsErrorCounters(root); err != nil {
+		log.Printf("not correlating with Btrfs: %v", err)
+	} else if len(counters) > 0 {
+		device = true
+		log.Printf("btrfs reports device errors: %s", strings.Join(counters, ", "))
+	}
+
+	for ii, v := range results {
+		if v.verdict != verdictFailed && v.verdict != verdictError {
+			continue
+		}
+		switch {
+		case zfs[v.path]:
+			results[ii].note = "device-level: zfs reports a permanent error"
+		case device:
+			results[ii].note = "possibly device-level: btrfs reports device errors"
+		case zfs != nil && v.verdict == verdictFailed:
+			results[ii].note = "application-level: not reported by the filesystem"
+		}
+	}
+}
+
+// zfsErrorPaths returns the files 'zpool status -v' lists as having
+// permanent errors, over all pools.
+func zfsErrorPaths() (map[string]bool, error) {
+	out, err := exec.Command("zpool", "status", "-v").Output()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "Permanent errors have been detected") {
+			listing = true
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if listing && trimmed == "" {
+			continue
+		}
+		if listing && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			// the next section of the status output, or the next pool
+			listing = false
+			continue
+		}
+		// files whose path can't be resolved show up as dataset:<0x..>
+		if listing && strings.HasPrefix(trimmed, "/") {
+			paths[trimmed] = true
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// btrfsErrorCounters returns the non-zero error counters 'btrfs device
+// stats' reports for the filesystem holding root.
+func btrfsErrorCounters(root string) ([]string, error) {
+	out, err := exec.Command("btrfs", "device", "stats", root).Output()
+	if err != nil {
+		return nil, err
+	}
+	var counters []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] != "0" {
+			counters = append(counters, fields[0]+"="+fields[1])
+		}
+	}
+	return counters, nil
+}
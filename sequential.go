@@ -0,0 +1,53 @@
+package md5summer
+
+import (
+	"os"
+	"sort"
+)
+
+// sequentialBuffer is the size of the reads of -sequential, large enough
+// for a tape drive to stream.
+const sequentialBuffer = 8 << 20
+
+// mediaPos is where the data of a file starts on its medium: the
+// partition, for tapes, and the offset or block in it.
+type mediaPos struct {
+	partition string
+	offset    int64
+}
+
+// mediaOrder returns the files a walk of root with opts would checksum
+// in the order their data is stored in, as far as startOf tells, so
+// they can be read without seeking back and forth. Files whose position
+// isn't known come last, in the order of the walk.
+func mediaOrder(src Source, root string, opts walkOptions) []string {
+	type located struct {
+		path  string
+		pos   mediaPos
+		known bool
+	}
+	var files []located
+	_, local := src.(localSource)
+	visitFiles(src, root, opts, func(path string, info os.FileInfo) {
+		f := located{path: path}
+		if local {
+			f.pos, f.known = startOf(path)
+		}
+		files = append(files, f)
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.known != b.known {
+			return a.known
+		}
+		if a.pos.partition != b.pos.partition {
+			return a.pos.partition < b.pos.partition
+		}
+		return a.pos.offset < b.pos.offset
+	})
+	paths := make([]string, len(files))
+	for ii, f := range files {
+		paths[ii] = f.path
+	}
+	return paths
+}
@@ -0,0 +1,158 @@
+package md5summer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// digestNames maps algorithms to their names in the Repr-Digest header
+// of RFC 9530 and the older Digest header of RFC 3230. Digest has no
+// name for crc32c, and neither has one for blake2b and xxhash, which
+// only get the ETag.
+var digestNames = map[string][2]string{
+	"md5":    {"md5", "MD5"},
+	"crc32c": {"crc32c", ""},
+	"sha1":   {"sha", "SHA"},
+	"sha256": {"sha-256", "SHA-256"},
+	"sha512": {"sha-512", "SHA-512"},
+}
+
+// runServeFiles implements the serve-files subcommand: it serves a tree
+// over HTTP and sends the digest of every file along in the ETag, Digest
+// and Repr-Digest headers, taken from a manifest or calculated on first
+// request.
+func runServeFiles(args []string) {
+	fs := flag.NewFlagSet("serve-files", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory to serve")
+	listen := fs.String("listen", "localhost:8080", "address to listen on")
+	manifest := fs.String("manifest", "", "manifest of -dir to take the digests from, files it doesn't list are checksummed on first request")
+	algo := fs.String("algo", "md5", "hash algorithm without -manifest: md5, sha1, sha256, sha512, blake2b, xxhash or crc32c")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: md5summer serve-files [-dir DIR] [-listen ADDR] [-manifest FILE] [-algo name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitError)
+	}
+	root, err := filepath.Abs(*dir)
+	if err != nil {
+		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", *dir, err))
+	}
+	if _, err := newHash(*algo); err != nil {
+		panic(fmt.Errorf("invalid -algo: %v", err))
+	}
+
+	d := &servedDigests{root: root, algo: *algo, cache: make(map[string]servedDigest)}
+	if *manifest != "" {
+		if d.manifest, err = LoadManifest(*manifest); err != nil {
+			panic(fmt.Errorf("cannot read manifest '%s': %v", *manifest, err))
+		}
+		if algo := d.manifest.Algorithm(); algo != "" {
+			d.algo = algo
+		}
+	}
+
+	files := http.FileServer(http.Dir(root))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			d.setHeaders(w.Header(), path.Clean("/"+r.URL.Path))
+		}
+		files.ServeHTTP(w, r)
+	})
+	log.Printf("serving %s on http://%s/", root, *listen)
+	if err := http.ListenAndServe(*listen, handler); err != nil {
+		panic(fmt.Errorf("cannot serve '%s': %v", root, err))
+	}
+}
+
+// servedDigest is a calculated digest, valid as long as the file keeps
+// its size and modification time.
+type servedDigest struct {
+	size  int64
+	mtime time.Time
+	sum   []byte
+}
+
+// servedDigests looks up the digests of the files served.
+type servedDigests struct {
+	root     string
+	algo     string
+	manifest *Manifest
+
+	mu    sync.Mutex
+	cache map[string]servedDigest
+}
+
+// setHeaders sets the digest headers for the file at urlPath, if it is a
+// regular file whose digest is known or can be calculated.
+func (d *servedDigests) setHeaders(h http.Header, urlPath string) {
+	name := filepath.Join(d.root, filepath.FromSlash(urlPath))
+	sum, err := d.digest(name)
+	if err != nil {
+		log.Printf("cannot checksum %s: %v", name, err)
+		return
+	}
+	if sum == nil {
+		return
+	}
+	h.Set("ETag", `"`+hex.EncodeToString(sum)+`"`)
+	names := digestNames[d.algo]
+	b64 := base64.StdEncoding.EncodeToString(sum)
+	if names[0] != "" {
+		h.Set("Repr-Digest", names[0]+"=:"+b64+":")
+	}
+	if names[1] != "" {
+		h.Set("Digest", names[1]+"="+b64)
+	}
+}
+
+// digest returns the digest of the file name, or nil if it isn't a
+// regular file.
+func (d *servedDigests) digest(name string) ([]byte, error) {
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		// the file server answers for those
+		return nil, nil
+	}
+	if d.manifest != nil {
+		if sum, ok := d.manifest.Lookup(name); ok {
+			return sum, nil
+		}
+	}
+	d.mu.Lock()
+	cached, ok := d.cache[name]
+	d.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.mtime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	file, err := openRead(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash, err := newHash(d.algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	sum := hash.Sum(nil)
+	d.mu.Lock()
+	d.cache[name] = servedDigest{info.Size(), info.ModTime(), sum}
+	d.mu.Unlock()
+	return sum, nil
+}
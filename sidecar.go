@@ -0,0 +1,107 @@
+package md5summer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarAlgorithm returns the algorithm of a checksum file by the naming
+// conventions of download mirrors and packages: MD5SUMS, sha256sums,
+// .md5sums, foo.md5 and so on.
+func sidecarAlgorithm(name string) (string, bool) {
+	if algo, ok := strings.CutSuffix(strings.ToLower(strings.TrimPrefix(name, ".")), "sums"); ok {
+		if algo == "b2" {
+			// as b2sum names them
+			algo = "blake2b"
+		}
+		if _, ok := hashes[algo]; ok {
+			return algo, true
+		}
+	}
+	algo := strings.TrimPrefix(filepath.Ext(name), ".")
+	if _, ok := hashes[algo]; ok && algo != "" {
+		return algo, true
+	}
+	return "", false
+}
+
+// verifySidecars walks root, and verifies the files listed in every
+// checksum file it finds against it, relative to the checksum file's
+// directory. Files named perDir, if set, are checksum files as
+// -per-dir-manifest writes them, the algorithm told by the length of the
+// digests rather than by the name. Results are ordered by path. If stop
+// is set, the verification ends at the first outcome it returns true for,
+// the only one returned then.
+func verifySidecars(root, perDir string, stop func(verification) bool) ([]verification, error) {
+	v := newVerifier()
+	v.stop = stop
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if v.stopped() {
+			return filepath.SkipAll
+		}
+		if info.IsDir() {
+			accessLog.record("list", path, 0, nil)
+			return nil
+		}
+		algo, named := sidecarAlgorithm(info.Name())
+		if perDir != "" && info.Name() == perDir {
+			// written in the algorithm of the run, whatever the name
+			named = false
+		} else if !named {
+			return nil
+		}
+		entries, err := readSidecar(path)
+		if err != nil {
+			v.record(verification{path: path, verdict: verdictError, err: err})
+			return nil
+		}
+		for _, e := range entries {
+			file := filepath.Join(filepath.Dir(path), e.name)
+			if !named {
+				var ok bool
+				if algo, ok = algoForDigest(e.sum); !ok {
+					v.record(verification{path: file, verdict: verdictError, err: fmt.Errorf("digest of unknown length in '%s'", path)})
+					continue
+				}
+			}
+			v.check(file, algo, e.sum)
+		}
+		return nil
+	})
+	return v.wait(), err
+}
+
+// sidecarEntry is one record of a checksum file.
+type sidecarEntry struct {
+	sum  []byte
+	name string
+}
+
+func readSidecar(path string) ([]sidecarEntry, error) {
+	f, err := openRead(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []sidecarEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, name, err := parseGNULine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sidecarEntry{sum, name})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,28 @@
+package md5summer
+
+import "testing"
+
+func TestSidecarAlgorithm(t *testing.T) {
+	tests := []struct {
+		name string
+		algo string
+	}{
+		{"MD5SUMS", "md5"},
+		{"SHA256SUMS", "sha256"},
+		{"sha512sums", "sha512"},
+		{"B2SUMS", "blake2b"},
+		{".md5sums", "md5"},
+		{"image.iso.sha1", "sha1"},
+		{"image.iso.md5", "md5"},
+		{"SUMS", ""},
+		{"md4sums", ""},
+		{"image.iso", ""},
+		{"md5", ""},
+	}
+	for _, test := range tests {
+		algo, ok := sidecarAlgorithm(test.name)
+		if algo != test.algo || ok != (test.algo != "") {
+			t.Errorf("%s: got %q, %v, want %q", test.name, algo, ok, test.algo)
+		}
+	}
+}
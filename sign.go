@@ -0,0 +1,130 @@
+package md5summer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Signed manifests, set up by -sign-key, carry an Ed25519 signature of
+// everything before it in a last '#signature ed25519ph SIGNATURE' line,
+// or in that line alone in a detached PATH.sig file. The signature is of
+// the Ed25519ph variant, over the SHA-512 of the manifest, so the
+// manifest can be signed as it is written. The key file holds the 32
+// byte seed of the private key, as written by the keygen subcommand, and
+// the pubkey subcommand prints the public key to verify with.
+
+// signaturePrefix starts the signature line.
+const signaturePrefix = headerPrefix + "signature ed25519ph "
+
+var ed25519ph = &ed25519.Options{Hash: crypto.SHA512}
+
+// loadSigningKey reads the private key of -sign-key.
+func loadSigningKey(name string) (ed25519.PrivateKey, error) {
+	seed, err := loadKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// loadPublicKey reads the public key of -pubkey.
+func loadPublicKey(name string) (ed25519.PublicKey, error) {
+	key, err := loadKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// signWriter signs everything written through it to w.
+type signWriter struct {
+	w   io.Writer
+	key ed25519.PrivateKey
+	sum hash.Hash
+}
+
+func newSignWriter(w io.Writer, key ed25519.PrivateKey) *signWriter {
+	return &signWriter{w: w, key: key, sum: sha512.New()}
+}
+
+func (sw *signWriter) Write(p []byte) (int, error) {
+	sw.sum.Write(p)
+	return sw.w.Write(p)
+}
+
+// signature returns the signature line of what was written, with the
+// line break.
+func (sw *signWriter) signature() (string, error) {
+	sig, err := sw.key.Sign(nil, sw.sum.Sum(nil), ed25519ph)
+	if err != nil {
+		return "", err
+	}
+	return signaturePrefix + base64.StdEncoding.EncodeToString(sig) + "\n", nil
+}
+
+// checkSignature checks the signature of the manifest name with key, the
+// one of its last line or else the one in name.sig.
+func checkSignature(name string, key ed25519.PublicKey) error {
+	f, err := openRead(name)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	signed, line := data, ""
+	if i := bytes.LastIndex(data, []byte(signaturePrefix)); i >= 0 && (i == 0 || data[i-1] == '\n') {
+		signed, line = data[:i], string(data[i:])
+	} else {
+		detached, err := os.ReadFile(name + ".sig")
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("the manifest is not signed and there is no detached signature")
+		}
+		if err != nil {
+			return err
+		}
+		line = string(detached)
+	}
+	encoded, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), signaturePrefix)
+	if !ok || strings.ContainsAny(encoded, "\n") {
+		return errors.New("malformed signature, or lines after it")
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	sum := sha512.Sum512(signed)
+	if err := ed25519.VerifyWithOptions(key, sum[:], sig, ed25519ph); err != nil {
+		return errors.New("the signature doesn't match, the manifest was changed or signed with another key")
+	}
+	return nil
+}
+
+// runPubkey implements the pubkey subcommand, printing the public key of
+// a -sign-key key in hex.
+func runPubkey(args []string) {
+	fs := flag.NewFlagSet("pubkey", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "usage: md5summer pubkey keyfile")
+		os.Exit(exitError)
+	}
+	key, err := loadSigningKey(fs.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+}
@@ -0,0 +1,73 @@
+package md5summer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signed returns manifest with the signature line key gives it.
+func signed(t *testing.T, manifest string, key ed25519.PrivateKey) (string, string) {
+	t.Helper()
+	var b strings.Builder
+	sw := newSignWriter(&b, key)
+	sw.Write([]byte(manifest))
+	line, err := sw.signature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String(), line
+}
+
+func TestCheckSignature(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	public := key.Public().(ed25519.PublicKey)
+
+	manifest := "#md5summer 1\nrL0Y20zC+Fzt72VPzMSk2A== a\nrL0Y20zC+Fzt72VPzMSk2A== #signature ed25519ph b\n"
+	body, line := signed(t, manifest, key)
+	_, otherLine := signed(t, manifest, otherKey)
+	tampered := strings.Replace(body, " a\n", " A\n", 1)
+
+	tests := []struct {
+		name     string
+		manifest string
+		// the detached signature, if any
+		sig string
+		ok  bool
+	}{
+		{name: "embedded", manifest: body + line, ok: true},
+		{name: "embedded with CRLF", manifest: body + strings.TrimSuffix(line, "\n") + "\r\n", ok: true},
+		{name: "detached", manifest: body, sig: line, ok: true},
+		{name: "embedded wins over detached", manifest: body + line, sig: otherLine, ok: true},
+		{name: "tampered", manifest: tampered + line},
+		{name: "tampered detached", manifest: tampered, sig: line},
+		{name: "other key", manifest: body + otherLine},
+		{name: "line after the signature", manifest: body + line + "rL0Y20zC+Fzt72VPzMSk2A== c\n"},
+		{name: "unsigned", manifest: body},
+		{name: "malformed", manifest: body + signaturePrefix + "not base64!\n"},
+		{name: "cut short", manifest: body + line[:len(line)-10] + "\n"},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		name := filepath.Join(dir, "manifest")
+		os.Remove(name + ".sig")
+		if err := os.WriteFile(name, []byte(test.manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if test.sig != "" {
+			if err := os.WriteFile(name+".sig", []byte(test.sig), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := checkSignature(name, public)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: the signature was accepted", test.name)
+		}
+	}
+}
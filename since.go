@@ -0,0 +1,62 @@
+package md5summer
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// sinceFilter leaves out the records that match an earlier manifest for
+// -since, or that are already in the manifest -append adds to, and
+// counts the files of the earlier manifest that still exist.
+type sinceFilter struct {
+	previous map[string][]byte
+	appended map[string][]byte
+	suppress *suppressions
+	// number of files of previous seen so far
+	present int
+}
+
+// resolvePaths returns sums with relative paths, as md5sum writes them,
+// joined to root, a local directory or, if remote, the URL of one.
+func resolvePaths(sums map[string][]byte, root string, remote bool) map[string][]byte {
+	resolved := make(map[string][]byte, len(sums))
+	for path, sum := range sums {
+		switch {
+		case remote && !strings.Contains(path, "://"):
+			path = strings.TrimSuffix(root, "/") + "/" + path
+		case !remote && !filepath.IsAbs(path):
+			path = filepath.Join(root, path)
+		}
+		resolved[path] = sum
+	}
+	return resolved
+}
+
+// keep reports whether the record of cs is to be written. It must be
+// called once for every file of the walk for removed to be right.
+func (f *sinceFilter) keep(cs checksum) bool {
+	prev, ok := f.previous[cs.filepath]
+	if ok {
+		f.present++
+	}
+	if _, ok := f.appended[cs.filepath]; ok {
+		return false
+	}
+	if ok && bytes.Equal(prev, cs.sum) {
+		return false
+	}
+	// known churn is not worth reporting
+	return f.previous == nil || !f.suppress.suppressed(cs.filepath)
+}
+
+// active reports whether keep leaves out anything at all.
+func (f *sinceFilter) active() bool {
+	return f.previous != nil || f.appended != nil
+}
+
+// removed returns the number of files of the earlier manifest that
+// weren't seen.
+func (f *sinceFilter) removed() int {
+	return len(f.previous) - f.present
+}
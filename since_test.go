@@ -0,0 +1,50 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"path/filepath"
+	"testing"
+)
+
+func TestSinceFilter(t *testing.T) {
+	old := md5.Sum([]byte("old"))
+	changed := md5.Sum([]byte("changed"))
+	root := t.TempDir()
+	// relative paths as md5sum writes them and an absolute one as -dir does
+	previous := resolvePaths(map[string][]byte{
+		"a":                      old[:],
+		"b":                      old[:],
+		filepath.Join(root, "c"): old[:],
+		filepath.Join("d", "e"):  old[:],
+	}, root, false)
+	f := &sinceFilter{previous: previous}
+	walked := []struct {
+		path string
+		sum  []byte
+		keep bool
+	}{
+		{"a", changed[:], true},
+		{"c", old[:], false},
+		{filepath.Join("d", "e"), old[:], false},
+		{"new", old[:], true},
+	}
+	for _, w := range walked {
+		cs := checksum{filepath: filepath.Join(root, w.path), sum: w.sum}
+		if got := f.keep(cs); got != w.keep {
+			t.Errorf("%s: kept %v, want %v", w.path, got, w.keep)
+		}
+	}
+	// only b is gone
+	if got := f.removed(); got != 1 {
+		t.Errorf("%d files removed, want 1", got)
+	}
+}
+
+func TestResolvePathsRemote(t *testing.T) {
+	got := resolvePaths(map[string][]byte{"a/b": nil, "s3://bucket/c": nil}, "s3://bucket/", true)
+	for _, path := range []string{"s3://bucket/a/b", "s3://bucket/c"} {
+		if _, ok := got[path]; !ok {
+			t.Errorf("%s missing from %v", path, got)
+		}
+	}
+}
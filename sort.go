@@ -0,0 +1,88 @@
+package md5summer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// lessFunc orders two checksums.
+type lessFunc func(a, b *checksum) bool
+
+// sortOrder returns the ordering selected by the -sort and -sort-natural
+// flags. Keys other than the path fall back to the path to break ties, so
+// the output is always deterministic.
+func sortOrder(key string, natural bool) (lessFunc, error) {
+	byPath := func(a, b *checksum) bool { return a.filepath < b.filepath }
+	if natural {
+		byPath = func(a, b *checksum) bool { return naturalLess(a.filepath, b.filepath) }
+	}
+	switch key {
+	case "path":
+		return byPath, nil
+	case "size":
+		return func(a, b *checksum) bool {
+			if a.size != b.size {
+				return a.size < b.size
+			}
+			return byPath(a, b)
+		}, nil
+	case "mtime":
+		return func(a, b *checksum) bool {
+			if !a.mtime.Equal(b.mtime) {
+				return a.mtime.Before(b.mtime)
+			}
+			return byPath(a, b)
+		}, nil
+	case "digest":
+		return func(a, b *checksum) bool {
+			if c := bytes.Compare(a.sum, b.sum); c != 0 {
+				return c < 0
+			}
+			return byPath(a, b)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown sort key '%s', expected one of path, size, mtime, digest", key)
+}
+
+// naturalLess compares strings the way a human would, treating runs of
+// digits as numbers so that "file2" sorts before "file10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			// compare the numbers without leading zeroes by length first,
+			// so arbitrarily long runs don't overflow an integer
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			// equal values, the one with fewer leading zeroes goes first
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// splitDigits splits s into its leading run of digits and the remainder.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
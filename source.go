@@ -0,0 +1,107 @@
+package md5summer
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source is where the files of a walk come from. The local filesystem is
+// the default, other sources let the same pipeline checksum trees that
+// are only reachable through some other API. Paths are whatever the
+// source understands, they end up in the manifest as they are.
+type Source interface {
+	// Walk calls fn for root and everything below it, like
+	// filepath.Walk, honoring filepath.SkipDir.
+	Walk(root string, fn filepath.WalkFunc) error
+	// Open opens the file at path for reading.
+	Open(path string) (io.ReadCloser, error)
+	// Stat describes the file at path, without following a final
+	// symbolic link.
+	Stat(path string) (os.FileInfo, error)
+}
+
+// hashReporter is implemented by sources that know the digests of some
+// of their files without reading them, like cloud drives do.
+type hashReporter interface {
+	// Hash returns the digest of the file at path for algo, if known.
+	Hash(path, algo string) ([]byte, bool)
+}
+
+// remoteSchemes are the schemes of the remote trees remoteSource knows.
+var remoteSchemes = []string{"hdfs", "drive", "onedrive", "s3", "gs"}
+
+// remoteSource returns the Source for a -dir naming a remote tree, and
+// false for local paths.
+func remoteSource(dir string) (Source, bool, error) {
+	switch {
+	case strings.HasPrefix(dir, "hdfs://"):
+		return hdfsSource{http.DefaultClient}, true, nil
+	case strings.HasPrefix(dir, "drive://"):
+		return newDriveSource("drive", googleDrive{}), true, nil
+	case strings.HasPrefix(dir, "onedrive://"):
+		return newDriveSource("onedrive", oneDrive{}), true, nil
+	case strings.HasPrefix(dir, "s3://"), strings.HasPrefix(dir, "gs://"):
+		s, err := newObjectSource(dir)
+		return s, true, err
+	}
+	return nil, false, nil
+}
+
+// untrustedSource hides the digests a source reports, so that every file
+// is read.
+type untrustedSource struct {
+	Source
+}
+
+// localSource is the Source of the local filesystem.
+type localSource struct{}
+
+func (localSource) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+func (localSource) Open(path string) (io.ReadCloser, error) {
+	file, err := openRead(path)
+	if err != nil {
+		return nil, err
+	}
+	adviseSequential(file.File)
+	return file, nil
+}
+
+func (localSource) Stat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+// fsSource is the Source of an fs.FS, so that walks can be run on a tree
+// in memory, like a testing/fstest.MapFS, or one that fails on purpose.
+// Paths are slash separated and unrooted as fs.ValidPath demands, and
+// there are no symbolic links to not follow.
+type fsSource struct {
+	fsys fs.FS
+}
+
+func (s fsSource) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(s.fsys, root, func(path string, d fs.DirEntry, err error) error {
+		var info os.FileInfo
+		if d != nil {
+			var ierr error
+			if info, ierr = d.Info(); err == nil {
+				err = ierr
+			}
+		}
+		return fn(path, info, err)
+	})
+}
+
+func (s fsSource) Open(path string) (io.ReadCloser, error) {
+	return s.fsys.Open(path)
+}
+
+func (s fsSource) Stat(path string) (os.FileInfo, error) {
+	return fs.Stat(s.fsys, path)
+}
@@ -0,0 +1,65 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRemoteSchemes(t *testing.T) {
+	for _, scheme := range remoteSchemes {
+		if _, ok, _ := remoteSource(scheme + "://bucket/path"); !ok {
+			t.Errorf("remoteSource doesn't know %s://", scheme)
+		}
+	}
+	if _, ok, _ := remoteSource("/local/path"); ok {
+		t.Error("remoteSource took a local path for remote")
+	}
+}
+
+func TestObjectSourceListedETags(t *testing.T) {
+	sum := md5.Sum([]byte("a"))
+	var heads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			heads.Add(1)
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+			return
+		}
+		fmt.Fprintf(w, `<ListBucketResult>
+<Contents><Key>d/a</Key><Size>1</Size><ETag>"%x"</ETag></Contents>
+<Contents><Key>d/parts</Key><Size>1</Size><ETag>"%x-2"</ETag></Contents>
+</ListBucketResult>`, sum, sum)
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := newObjectSource("s3://bucket/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Walk("s3://bucket/d", func(_ string, _ os.FileInfo, err error) error { return err }); err != nil {
+		t.Fatal(err)
+	}
+	walked := heads.Load()
+
+	if got, ok := s.Hash("s3://bucket/d/a", "md5"); !ok || string(got) != string(sum[:]) {
+		t.Errorf("got %x, %v, want %x", got, ok, sum)
+	}
+	// uploaded in parts, the ETag is no MD5
+	if got, ok := s.Hash("s3://bucket/d/parts", "md5"); ok {
+		t.Errorf("got %x for an object uploaded in parts", got)
+	}
+	if n := heads.Load() - walked; n != 0 {
+		t.Errorf("%d HEAD requests for listed objects", n)
+	}
+	if _, ok := s.Hash("s3://bucket/d/unlisted", "md5"); !ok || heads.Load()-walked != 1 {
+		t.Error("an object not listed wasn't asked for")
+	}
+}
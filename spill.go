@@ -0,0 +1,205 @@
+package md5summer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// spillSorter sorts checksums with bounded memory: they are sorted in
+// runs of at most limit records, each spilled to a temporary file, and
+// the runs are merged when the records are read back.
+type spillSorter struct {
+	less  lessFunc
+	limit int
+	buf   []checksum
+	runs  []*os.File
+	// the first error spilling a run, reported by each
+	err error
+}
+
+// spillRecord is a checksum as it is stored in a run.
+type spillRecord struct {
+	Path  string
+	Sum   []byte
+	Size  int64
+	Mtime time.Time
+	Algo  string
+	// the further digests, by algorithm in the order of ExtraSums
+	ExtraAlgos []string
+	ExtraSums  [][]byte
+}
+
+func newSpillRecord(cs checksum) spillRecord {
+	rec := spillRecord{Path: cs.filepath, Sum: cs.sum, Size: cs.size, Mtime: cs.mtime, Algo: cs.algo}
+	for _, d := range cs.extra {
+		rec.ExtraAlgos = append(rec.ExtraAlgos, d.algo)
+		rec.ExtraSums = append(rec.ExtraSums, d.sum)
+	}
+	return rec
+}
+
+func (rec spillRecord) checksum() checksum {
+	cs := checksum{rec.Path, rec.Sum, rec.Size, rec.Mtime, rec.Algo, nil}
+	for i, algo := range rec.ExtraAlgos {
+		cs.extra = append(cs.extra, digest{algo, rec.ExtraSums[i]})
+	}
+	return cs
+}
+
+// add adds a checksum, spilling the buffered ones once there are limit.
+func (s *spillSorter) add(cs checksum) {
+	if s.err != nil {
+		return
+	}
+	s.buf = append(s.buf, cs)
+	if len(s.buf) >= s.limit {
+		s.err = s.spill()
+	}
+}
+
+// spill writes the buffered checksums to a new run, sorted.
+func (s *spillSorter) spill() error {
+	if len(s.runs) >= maxRuns {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	sort.Slice(s.buf, func(i, j int) bool { return s.less(&s.buf[i], &s.buf[j]) })
+	f, err := os.CreateTemp("", "md5summer-run-*")
+	if err != nil {
+		return err
+	}
+	// the run is gone once the last handle is closed
+	os.Remove(f.Name())
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, cs := range s.buf {
+		if err := enc.Encode(newSpillRecord(cs)); err != nil {
+			return err
+		}
+	}
+	s.buf = s.buf[:0]
+	return w.Flush()
+}
+
+// runReader reads back the records of a run in order.
+type runReader struct {
+	dec  *gob.Decoder
+	head checksum
+}
+
+func (r *runReader) next() (bool, error) {
+	var rec spillRecord
+	if err := r.dec.Decode(&rec); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r.head = rec.checksum()
+	return true, nil
+}
+
+// runHeap orders runs by their next record.
+type runHeap struct {
+	runs []*runReader
+	less lessFunc
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return h.less(&h.runs[i].head, &h.runs[j].head) }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)         { h.runs = append(h.runs, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+// maxRuns is the number of runs that are merged into one before
+// another is spilled, so that only so many files are open at a time.
+const maxRuns = 64
+
+// each calls fn with all checksums added, in order, and releases the
+// runs.
+func (s *spillSorter) each(fn func(checksum)) error {
+	defer s.close()
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge(func(cs checksum) error {
+		fn(cs)
+		return nil
+	})
+}
+
+// close releases the runs.
+func (s *spillSorter) close() {
+	for _, f := range s.runs {
+		f.Close()
+	}
+	s.runs = nil
+}
+
+// compact merges all runs into a single one.
+func (s *spillSorter) compact() error {
+	f, err := os.CreateTemp("", "md5summer-run-*")
+	if err != nil {
+		return err
+	}
+	os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	err = s.merge(func(cs checksum) error {
+		return enc.Encode(newSpillRecord(cs))
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	s.close()
+	s.runs = []*os.File{f}
+	return err
+}
+
+// merge calls fn with the records of all runs, in order.
+func (s *spillSorter) merge(fn func(checksum) error) error {
+	h := &runHeap{less: s.less}
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &runReader{dec: gob.NewDecoder(bufio.NewReader(f))}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := fn(r.head); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
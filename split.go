@@ -0,0 +1,68 @@
+package md5summer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// topLevelSlice names the slice of the files directly below the root,
+// which belong to no top-level directory.
+const topLevelSlice = "_toplevel"
+
+// splitSums divides sums into slices, keyed by the name of the manifest
+// each is written to. With perEntries > 0 every slice holds that many
+// records, otherwise there is one slice per top-level directory below
+// root. The order of sums is kept within each slice.
+func splitSums(root string, sums []checksum, perEntries int) (map[string][]checksum, error) {
+	slices := make(map[string][]checksum)
+	for i, cs := range sums {
+		var name string
+		if perEntries > 0 {
+			name = fmt.Sprintf("part-%04d", i/perEntries+1)
+		} else {
+			rel, err := filepath.Rel(root, cs.filepath)
+			if err != nil {
+				return nil, err
+			}
+			top, _, nested := strings.Cut(rel, string(filepath.Separator))
+			if !nested {
+				top = topLevelSlice
+			} else if top == topLevelSlice {
+				return nil, fmt.Errorf("directory '%s' clashes with the slice of top-level files", top)
+			}
+			name = top
+		}
+		slices[name] = append(slices[name], cs)
+	}
+	return slices, nil
+}
+
+// writeSplitManifests writes every slice of sums to a manifest NAME.md5
+// in dir, creating dir if needed.
+func writeSplitManifests(dir, root string, sums []checksum, perEntries int, format recordFormat) error {
+	slices, err := splitSums(root, sums, perEntries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, slice := range slices {
+		err := writeAtomic(filepath.Join(dir, name+".md5"), func(w io.Writer) error {
+			rw := &recordWriter{w: w, format: format}
+			for _, cs := range slice {
+				if err := rw.write(&cs); err != nil {
+					return err
+				}
+			}
+			return rw.close()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package md5summer
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// runStats are the numbers of a run written by -summary-json, for CI
+// pipelines to parse instead of the log.
+type runStats struct {
+	// files checksummed or verified and the bytes read for them
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// files or directories that couldn't be read
+	Errors         int     `json:"errors"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// number of files by verdict, when verifying
+	Verdicts    map[string]int `json:"verdicts,omitempty"`
+	Interrupted bool           `json:"interrupted"`
+	ExitCode    int            `json:"exit_code"`
+}
+
+// walkStats returns the numbers of a checksumming run followed by st.
+func walkStats(st *status, failures *fileErrors, interrupted bool) runStats {
+	s := runStats{Files: st.files.Load(), Bytes: st.bytes.Load(), Errors: failures.count(), Interrupted: interrupted}
+	switch {
+	case interrupted:
+		s.ExitCode = exitInterrupted
+	case s.Errors > 0:
+		s.ExitCode = exitError
+	}
+	return s.timed(time.Since(st.started))
+}
+
+// verificationStats returns the numbers of a verification that started
+// at started.
+func verificationStats(results []verification, started time.Time) runStats {
+	counts := tally(results)
+	s := runStats{Files: int64(len(results)), Errors: counts[verdictError], Verdicts: counts, ExitCode: verifiedExitCode(results)}
+	for _, v := range results {
+		s.Bytes += v.size
+	}
+	return s.timed(time.Since(started))
+}
+
+func (s runStats) timed(elapsed time.Duration) runStats {
+	s.ElapsedSeconds = elapsed.Seconds()
+	if elapsed > 0 {
+		s.BytesPerSecond = float64(s.Bytes) / elapsed.Seconds()
+	}
+	return s
+}
+
+// write writes the numbers as a JSON object to the file name, or to
+// stderr for "-".
+func (s runStats) write(name string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if name == "-" {
+		_, err = os.Stderr.Write(b)
+		return err
+	}
+	return writeAtomic(name, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
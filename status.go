@@ -0,0 +1,159 @@
+package md5summer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// errSkipped is returned by reads of a file the user chose to skip
+	errSkipped = errors.New("skipped")
+	// errAborted terminates a walk the user chose to abort
+	errAborted = errors.New("aborted")
+)
+
+// status tracks a walk while it is running, and lets the user pause it,
+// skip files or abort it.
+type status struct {
+	started time.Time
+	// files and bytes whose checksum has been calculated
+	files atomic.Int64
+	bytes atomic.Int64
+	// set once the user asks to abort, no new files are started after that
+	aborted atomic.Bool
+
+	lk     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	// files currently being read
+	active map[*activeFile]struct{}
+}
+
+// activeFile is a file that a worker is currently reading.
+type activeFile struct {
+	path    string
+	size    int64
+	started time.Time
+	read    atomic.Int64
+	skip    atomic.Bool
+}
+
+func newStatus() *status {
+	st := &status{started: time.Now(), active: make(map[*activeFile]struct{})}
+	st.cond = sync.NewCond(&st.lk)
+	return st
+}
+
+// start registers a file that is about to be read.
+func (st *status) start(path string, size int64) *activeFile {
+	af := &activeFile{path: path, size: size, started: time.Now()}
+	st.lk.Lock()
+	st.active[af] = struct{}{}
+	st.lk.Unlock()
+	return af
+}
+
+// finish unregisters a file, counting it as done if ok is set.
+func (st *status) finish(af *activeFile, ok bool) {
+	st.lk.Lock()
+	delete(st.active, af)
+	st.lk.Unlock()
+	if ok {
+		st.files.Add(1)
+	}
+}
+
+// reused counts a file whose digest was known without reading it as
+// done.
+func (st *status) reused(size int64) {
+	st.files.Add(1)
+	st.bytes.Add(size)
+}
+
+// activeFiles returns the files currently being read, longest running
+// first.
+func (st *status) activeFiles() []*activeFile {
+	st.lk.Lock()
+	files := make([]*activeFile, 0, len(st.active))
+	for af := range st.active {
+		files = append(files, af)
+	}
+	st.lk.Unlock()
+	sort.Slice(files, func(i, j int) bool { return files[i].started.Before(files[j].started) })
+	return files
+}
+
+// setPaused pauses or resumes all reads.
+func (st *status) setPaused(paused bool) {
+	st.lk.Lock()
+	st.paused = paused
+	st.lk.Unlock()
+	st.cond.Broadcast()
+}
+
+func (st *status) isPaused() bool {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	return st.paused
+}
+
+// waitWhilePaused blocks for as long as the walk is paused.
+func (st *status) waitWhilePaused() {
+	st.lk.Lock()
+	for st.paused {
+		st.cond.Wait()
+	}
+	st.lk.Unlock()
+}
+
+// skipOldest skips the file that has been read for the longest time.
+func (st *status) skipOldest() {
+	if files := st.activeFiles(); len(files) > 0 {
+		files[0].skip.Store(true)
+	}
+}
+
+// abort stops the walk from starting any new files and resumes it if it
+// was paused, so that files being read can finish.
+func (st *status) abort() {
+	st.aborted.Store(true)
+	st.setPaused(false)
+}
+
+// contextReader fails reads once ctx is done, so that a cancelled walk
+// doesn't finish reading large files first.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// progressReader counts the bytes read from a file and honours pause and
+// skip requests between reads.
+type progressReader struct {
+	r  io.Reader
+	af *activeFile
+	st *status
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	pr.st.waitWhilePaused()
+	if pr.af.skip.Load() {
+		return 0, errSkipped
+	}
+	n, err := pr.r.Read(p)
+	pr.af.read.Add(int64(n))
+	pr.st.bytes.Add(int64(n))
+	return n, err
+}
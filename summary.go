@@ -0,0 +1,70 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// summary holds the top-line numbers of a run.
+type summary struct {
+	files      int
+	bytes      int64
+	unique     int
+	duplicates int
+	errors     int
+	// md5 over the records of all files in path order, with their paths
+	// relative to the root, equal for two trees with the same paths and
+	// contents wherever they are
+	tree []byte
+}
+
+// summarize returns the summary of a run that checksummed sums below root
+// and couldn't read failed more files.
+func summarize(sums []checksum, root string, failed int) summary {
+	s := summary{errors: failed}
+	seen := make(map[string]bool)
+	sorted := make([]checksum, len(sums))
+	copy(sorted, sums)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].filepath < sorted[j].filepath })
+	tree := md5.New()
+	for _, cs := range sorted {
+		s.files++
+		s.bytes += cs.size
+		if seen[string(cs.sum)] {
+			s.duplicates++
+		} else {
+			seen[string(cs.sum)] = true
+			s.unique++
+		}
+		if rel, err := filepath.Rel(root, cs.filepath); err == nil {
+			cs.filepath = filepath.ToSlash(rel)
+		}
+		fmt.Fprintln(tree, cs.String())
+	}
+	s.tree = tree.Sum(nil)
+	return s
+}
+
+// json returns the summary as a JSON object.
+func (s summary) json() string {
+	b, _ := json.Marshal(struct {
+		Files      int    `json:"files"`
+		Bytes      int64  `json:"bytes"`
+		Unique     int    `json:"unique"`
+		Duplicates int    `json:"duplicates"`
+		Errors     int    `json:"errors"`
+		Tree       string `json:"tree"`
+	}{s.files, s.bytes, s.unique, s.duplicates, s.errors, base64.StdEncoding.EncodeToString(s.tree)})
+	return string(b)
+}
+
+func (s summary) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "files %d\nbytes %d\nunique %d\nduplicates %d\nerrors %d\ntree %s\n",
+		s.files, s.bytes, s.unique, s.duplicates, s.errors, base64.StdEncoding.EncodeToString(s.tree))
+	return err
+}
@@ -0,0 +1,64 @@
+package md5summer
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// brokenFS fails to open the file called broken.
+type brokenFS struct {
+	fstest.MapFS
+}
+
+func (f brokenFS) Open(name string) (fs.File, error) {
+	if name == "broken" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("input/output error")}
+	}
+	return f.MapFS.Open(name)
+}
+
+func TestSummarizeFailedFile(t *testing.T) {
+	tree := brokenFS{fstest.MapFS{
+		"a":      {Data: []byte("a")},
+		"broken": {Data: []byte("b")},
+	}}
+	// as with -keep-going
+	failures := &fileErrors{}
+	sums, err := walkPath(context.Background(), ".", &checksums{}, newStatus(), walkOptions{source: fsSource{tree}, failures: failures})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := summarize(sums, ".", failures.count()).writeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"files 1\n", "errors 1\n"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("summary lacks %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestSummarizeTreeMoved(t *testing.T) {
+	sums := func(root string) []checksum {
+		return []checksum{
+			{filepath: filepath.Join(root, "a"), sum: []byte{1}, algo: "md5"},
+			{filepath: filepath.Join(root, "d", "b"), sum: []byte{2}, algo: "md5"},
+		}
+	}
+	here, there := t.TempDir(), t.TempDir()
+	a := summarize(sums(here), here, 0)
+	b := summarize(sums(there), there, 0)
+	if string(a.tree) != string(b.tree) {
+		t.Errorf("the same tree under two roots hashed to %x and %x", a.tree, b.tree)
+	}
+	grown := summarize(append(sums(here), checksum{filepath: filepath.Join(here, "c"), sum: []byte{3}, algo: "md5"}), here, 0)
+	if string(a.tree) == string(grown.tree) {
+		t.Error("another file left the tree hash alone")
+	}
+}
@@ -0,0 +1,142 @@
+package md5summer
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// suppression silences differences in files that are known to churn,
+// until it expires.
+type suppression struct {
+	rule    policyRule
+	expires time.Time
+	reason  string
+	// number of differences suppressed so far
+	hits int
+}
+
+// suppressions are the rules of a suppression file, applied to paths
+// below root. A nil *suppressions suppresses nothing.
+type suppressions struct {
+	root  string
+	now   time.Time
+	rules []*suppression
+}
+
+// readSuppressions reads a suppression file with one "PATTERN EXPIRY
+// REASON" rule per line, where EXPIRY is a date like 2026-12-31 or
+// "never" and the reason is free text, for example:
+//
+//	var/cache   2026-12-31  rebuilt nightly by the CI
+//	*.sqlite    never       application databases change all the time
+//
+// Patterns match like those of a policy. Blank lines and lines starting
+// with '#' are ignored.
+func readSuppressions(name, root string, now time.Time) (*suppressions, error) {
+	f, err := openRead(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &suppressions{root: root, now: now}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected PATTERN EXPIRY REASON", n)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern '%s': %v", n, fields[0], err)
+		}
+		var expires time.Time
+		if fields[1] != "never" {
+			day, err := time.ParseInLocation("2006-01-02", fields[1], time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: expiry must be a date like 2006-01-02 or never: %v", n, err)
+			}
+			// the rule holds through the whole of its last day
+			expires = day.AddDate(0, 0, 1)
+		}
+		s.rules = append(s.rules, &suppression{
+			rule:    policyRule{pattern: fields[0]},
+			expires: expires,
+			reason:  strings.Join(fields[2:], " "),
+		})
+	}
+	return s, scanner.Err()
+}
+
+func (s *suppression) expired(now time.Time) bool {
+	return !s.expires.IsZero() && !now.Before(s.expires)
+}
+
+// suppressed reports whether a difference in the file at filePath is to
+// be left out, counting it against the rule that suppresses it.
+func (s *suppressions) suppressed(filePath string) bool {
+	r := s.match(filePath)
+	if r == nil {
+		return false
+	}
+	r.hits++
+	return true
+}
+
+// match returns the rule that suppresses differences in the file at
+// filePath, nil if none does.
+func (s *suppressions) match(filePath string) *suppression {
+	if s == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(s.root, filePath)
+	if err != nil {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	for _, r := range s.rules {
+		if !r.expired(s.now) && r.rule.matches(rel) {
+			return r
+		}
+	}
+	return nil
+}
+
+// filter drops the results that aren't OK but are suppressed.
+func (s *suppressions) filter(results []verification) []verification {
+	kept := results[:0]
+	for _, v := range results {
+		if v.verdict == verdictOK || !s.suppressed(v.path) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// report logs how many differences were suppressed, and the rules that
+// have expired or suppressed nothing and could be cleaned up.
+func (s *suppressions) report() {
+	if s == nil {
+		return
+	}
+	total := 0
+	for _, r := range s.rules {
+		total += r.hits
+		switch {
+		case r.expired(s.now):
+			log.Printf("suppression of %s (%s) expired on %s", r.rule.pattern, r.reason, r.expires.AddDate(0, 0, -1).Format("2006-01-02"))
+		case r.hits == 0:
+			log.Printf("suppression of %s (%s) no longer matches anything", r.rule.pattern, r.reason)
+		}
+	}
+	if total > 0 {
+		log.Printf("suppressed %d known differences", total)
+	}
+}
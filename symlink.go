@@ -0,0 +1,114 @@
+package md5summer
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// followSource is the local filesystem with symbolic links followed,
+// those to directories included, set up by -follow-symlinks. A link
+// leading back to a directory it is below would be walked forever, it
+// is skipped like 'find -L' does.
+type followSource struct {
+	localSource
+}
+
+// Stat follows symbolic links, unlike other sources.
+func (followSource) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (s followSource) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walk(root, info, nil, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk walks path like filepath.Walk does, ancestors are the directories
+// above it.
+func (s followSource) walk(path string, info os.FileInfo, ancestors []os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			log.Printf("not following %s, it leads back to a directory above it", path)
+			return nil
+		}
+	}
+	if err := fn(path, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	f, err := os.Open(path)
+	var names []string
+	if err == nil {
+		names, err = f.Readdirnames(-1)
+		f.Close()
+	}
+	if err != nil {
+		if err := fn(path, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+	sort.Strings(names)
+	ancestors = append(ancestors, info)
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		childInfo, err := os.Stat(child)
+		if err != nil {
+			// a dangling link, report it as what it is
+			if linfo, lerr := os.Lstat(child); lerr == nil {
+				childInfo = linfo
+			}
+			err = fn(child, childInfo, err)
+		} else {
+			err = s.walk(child, childInfo, ancestors, fn)
+		}
+		if err == filepath.SkipDir {
+			// a file asked to skip the rest of its directory
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkTextSource is the local filesystem with symbolic links checksummed
+// by the path they hold instead of the file they point to, set up by
+// -hash-link-target-path.
+type linkTextSource struct {
+	localSource
+}
+
+func (s linkTextSource) Open(path string) (io.ReadCloser, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return s.localSource.Open(path)
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(target)), nil
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package md5summer
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// readKeys switches the controlling terminal to unbuffered input without
+// echo and delivers every key pressed. The returned function restores the
+// terminal. If there is no terminal no keys are ever delivered.
+func readKeys() (<-chan byte, func()) {
+	keys := make(chan byte)
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return keys, func() {}
+	}
+	var saved syscall.Termios
+	if err := termios(tty, ioctlGetTermios, &saved); err != nil {
+		tty.Close()
+		return keys, func() {}
+	}
+	raw := saved
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	termios(tty, ioctlSetTermios, &raw)
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := tty.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	return keys, func() {
+		termios(tty, ioctlSetTermios, &saved)
+		// the reader is left blocked on the terminal, which is harmless
+		// as we're about to exit
+	}
+}
+
+func termios(tty *os.File, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
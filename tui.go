@@ -0,0 +1,188 @@
+package md5summer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the screen is redrawn
+	tuiInterval = 250 * time.Millisecond
+	// number of throughput samples in the graph
+	tuiSamples = 60
+	// number of diagnostics kept in the error pane
+	tuiMessages = 8
+)
+
+// tui draws the status of a running walk on stderr and turns key presses
+// on the terminal into pause, skip and abort requests.
+type tui struct {
+	st       *status
+	out      io.Writer
+	samples  []float64
+	lastRead int64
+
+	lk       sync.Mutex
+	messages []string
+}
+
+// startTUI starts drawing st on stderr. Diagnostics are shown in the
+// error pane instead of being printed while the screen is up. The
+// returned function stops the screen and may be called more than once.
+func startTUI(st *status) func() {
+	t := &tui{st: st, out: redactOutput(os.Stderr)}
+	logOut := log.Writer()
+	log.SetOutput(t)
+
+	keys, restore := readKeys()
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(tuiInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case k := <-keys:
+				t.handleKey(k)
+			case <-ticker.C:
+				t.sample()
+			}
+			t.draw()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+			restore()
+			log.SetOutput(logOut)
+			// replay the diagnostics so they aren't lost with the screen
+			for _, msg := range t.messages {
+				fmt.Fprint(logOut, msg)
+			}
+		})
+	}
+}
+
+// Write collects diagnostics for the error pane.
+func (t *tui) Write(p []byte) (int, error) {
+	t.lk.Lock()
+	t.messages = append(t.messages, string(p))
+	t.lk.Unlock()
+	return len(p), nil
+}
+
+func (t *tui) handleKey(k byte) {
+	switch k {
+	case 'p', ' ':
+		t.st.setPaused(!t.st.isPaused())
+	case 's':
+		t.st.skipOldest()
+	case 'q':
+		t.st.abort()
+	}
+}
+
+// sample records the throughput since the last sample.
+func (t *tui) sample() {
+	read := t.st.bytes.Load()
+	t.samples = append(t.samples, float64(read-t.lastRead)/tuiInterval.Seconds())
+	if len(t.samples) > tuiSamples {
+		t.samples = t.samples[1:]
+	}
+	t.lastRead = read
+}
+
+func (t *tui) draw() {
+	var b strings.Builder
+	// move to the top left corner and clear the screen
+	b.WriteString("\x1b[H\x1b[2J")
+
+	state := "running"
+	switch {
+	case t.st.aborted.Load():
+		state = "aborting"
+	case t.st.isPaused():
+		state = "paused"
+	}
+	elapsed := time.Since(t.st.started)
+	read := t.st.bytes.Load()
+	fmt.Fprintf(&b, "md5summer  %s  %d files  %s  %s/s avg  %s\r\n\r\n",
+		state, t.st.files.Load(), humanBytes(read),
+		humanBytes(int64(float64(read)/elapsed.Seconds())), elapsed.Truncate(time.Second))
+
+	current := 0.0
+	if len(t.samples) > 0 {
+		current = t.samples[len(t.samples)-1]
+	}
+	fmt.Fprintf(&b, "throughput %s/s\r\n%s\r\n\r\n", humanBytes(int64(current)), sparkline(t.samples))
+
+	b.WriteString("workers\r\n")
+	for _, af := range t.st.activeFiles() {
+		pct := 100.0
+		if af.size > 0 {
+			pct = 100 * float64(af.read.Load()) / float64(af.size)
+		}
+		fmt.Fprintf(&b, "  %5.1f%%  %s\r\n", pct, af.path)
+	}
+
+	b.WriteString("\r\nerrors\r\n")
+	t.lk.Lock()
+	msgs := t.messages
+	if len(msgs) > tuiMessages {
+		msgs = msgs[len(msgs)-tuiMessages:]
+	}
+	for _, msg := range msgs {
+		b.WriteString("  " + strings.TrimRight(msg, "\n") + "\r\n")
+	}
+	t.lk.Unlock()
+
+	b.WriteString("\r\np pause/resume  s skip oldest file  q abort\r\n")
+	io.WriteString(t.out, b.String())
+}
+
+// sparkline renders values as a row of block characters scaled to the
+// largest value.
+func sparkline(values []float64) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	blocks := []rune(bars)
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		ii := 0
+		if max > 0 {
+			ii = int(v / max * float64(len(blocks)-1))
+		}
+		b.WriteRune(blocks[ii])
+	}
+	return b.String()
+}
+
+// humanBytes formats n with a binary unit prefix.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,378 @@
+package md5summer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploader stores named objects in a bucket or container, below a
+// prefix that is not part of the names it takes and returns.
+type uploader interface {
+	put(name string, body io.Reader, size int64, contentType string) error
+	list() ([]string, error)
+	remove(name string) error
+}
+
+// newUploader returns an uploader for a destination URL:
+//
+//	s3://bucket/prefix/    credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+//	                       and AWS_SESSION_TOKEN, region from AWS_REGION, endpoint
+//	                       from AWS_ENDPOINT_URL for S3-compatible stores
+//	gs://bucket/prefix/    HMAC credentials from GOOGLE_ACCESS_KEY_ID and
+//	                       GOOGLE_SECRET_ACCESS_KEY
+//	az://account/container/prefix/  shared access signature from AZURE_STORAGE_SAS_TOKEN
+//
+// Objects are written with server-side encryption: requested explicitly
+// from S3, always on for Google Cloud Storage and Azure.
+func newUploader(dest string) (uploader, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := os.Getenv("AWS_ENDPOINT_URL")
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		return &s3Uploader{
+			endpoint: endpoint,
+			bucket:   u.Host,
+			prefix:   prefix,
+			region:   region,
+			keyID:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:    os.Getenv("AWS_SESSION_TOKEN"),
+			sse:      "AES256",
+		}, nil
+	case "gs":
+		// the XML API of Cloud Storage speaks the S3 protocol
+		return &s3Uploader{
+			endpoint: "https://storage.googleapis.com",
+			bucket:   u.Host,
+			prefix:   prefix,
+			region:   "auto",
+			keyID:    os.Getenv("GOOGLE_ACCESS_KEY_ID"),
+			secret:   os.Getenv("GOOGLE_SECRET_ACCESS_KEY"),
+		}, nil
+	case "az":
+		parts := strings.SplitN(prefix, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("'%s' names no container", dest)
+		}
+		az := &azureUploader{account: u.Host, container: parts[0], sas: strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")}
+		if len(parts) == 2 {
+			az.prefix = parts[1]
+		}
+		return az, nil
+	}
+	return nil, fmt.Errorf("unsupported upload destination '%s', expected s3://, gs:// or az://", dest)
+}
+
+// s3Uploader puts objects with path-style requests signed with AWS
+// signature version 4.
+type s3Uploader struct {
+	endpoint, bucket, prefix string
+	region                   string
+	keyID, secret, token     string
+	// value of x-amz-server-side-encryption, if any
+	sse string
+}
+
+// request returns a request for an object, or the bucket if name is
+// empty. The query must already be in canonical form: sorted by key and
+// encoded with uriEncode.
+func (s *s3Uploader) request(method, name, query string, body io.Reader) (*http.Request, error) {
+	if s.keyID == "" || s.secret == "" {
+		return nil, fmt.Errorf("no credentials for bucket '%s'", s.bucket)
+	}
+	path := "/" + s.bucket
+	if name != "" {
+		path += "/" + s.prefix + name
+	}
+	u := strings.TrimSuffix(s.endpoint, "/") + uriEncode(path)
+	if query != "" {
+		u += "?" + query
+	}
+	return http.NewRequest(method, u, body)
+}
+
+func (s *s3Uploader) put(name string, body io.Reader, size int64, contentType string) error {
+	req, err := s.request("PUT", name, "", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if s.sse != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.sse)
+	}
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	s.sign(req, time.Now().UTC())
+	_, err = doRequest(req)
+	return err
+}
+
+func (s *s3Uploader) list() ([]string, error) {
+	var names []string
+	marker := ""
+	for {
+		// version 1 listing, which Cloud Storage understands as well
+		query := "prefix=" + uriEncode(s.prefix)
+		if marker != "" {
+			query = "marker=" + uriEncode(marker) + "&" + query
+		}
+		req, err := s.request("GET", "", strings.ReplaceAll(query, "/", "%2F"), nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, time.Now().UTC())
+		body, err := doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents    []struct{ Key string }
+			IsTruncated bool
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.prefix))
+			marker = c.Key
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return names, nil
+		}
+	}
+}
+
+func (s *s3Uploader) remove(name string) error {
+	req, err := s.request("DELETE", name, "", nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, time.Now().UTC())
+	_, err = doRequest(req)
+	return err
+}
+
+// sign adds an AWS signature version 4 Authorization header to req,
+// covering the host and all x-amz-* headers. The body is not part of the
+// signature, which S3 allows over TLS.
+func (s *s3Uploader) sign(req *http.Request, now time.Time) {
+	const payload = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(v[0])
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.keyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
+
+// uriEncode percent-encodes everything in path but unreserved characters
+// and slashes, as AWS signatures require.
+func uriEncode(path string) string {
+	var b strings.Builder
+	for ii := 0; ii < len(path); ii++ {
+		c := path[ii]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// azureUploader puts block blobs authorized by a shared access signature.
+type azureUploader struct {
+	account, container, prefix string
+	sas                        string
+}
+
+// request returns a request for a blob, or the container if name is
+// empty, with the shared access signature appended to query.
+func (a *azureUploader) request(method, name, query string, body io.Reader) (*http.Request, error) {
+	if a.sas == "" {
+		return nil, fmt.Errorf("no shared access signature for account '%s'", a.account)
+	}
+	path := "/" + a.container
+	if name != "" {
+		path += "/" + a.prefix + name
+	}
+	if query != "" {
+		query += "&"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s.blob.core.windows.net%s?%s%s", a.account, uriEncode(path), query, a.sas), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Version", "2021-08-06")
+	return req, nil
+}
+
+func (a *azureUploader) put(name string, body io.Reader, size int64, contentType string) error {
+	req, err := a.request("PUT", name, "", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	_, err = doRequest(req)
+	return err
+}
+
+func (a *azureUploader) list() ([]string, error) {
+	var names []string
+	marker := ""
+	for {
+		query := "restype=container&comp=list&prefix=" + url.QueryEscape(a.prefix)
+		if marker != "" {
+			query += "&marker=" + url.QueryEscape(marker)
+		}
+		req, err := a.request("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Blobs struct {
+				Blob []struct{ Name string }
+			}
+			NextMarker string
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, b := range result.Blobs.Blob {
+			names = append(names, strings.TrimPrefix(b.Name, a.prefix))
+		}
+		if result.NextMarker == "" {
+			return names, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (a *azureUploader) remove(name string) error {
+	req, err := a.request("DELETE", name, "", nil)
+	if err != nil {
+		return err
+	}
+	_, err = doRequest(req)
+	return err
+}
+
+// doRequest sends req and returns the body of a successful response.
+func doRequest(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// the query may hold a shared access signature
+		u := *req.URL
+		u.RawQuery = ""
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, u.String(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// uploadRun uploads the manifest in the file at path and the summary of
+// the run next to each other, named after the time the run finished.
+// With keep set, older runs beyond the retention policy are removed
+// afterwards.
+func uploadRun(dest, path string, s summary, finished time.Time, keep int) error {
+	up, err := newUploader(dest)
+	if err != nil {
+		return err
+	}
+	base := runName(finished)
+
+	f, err := openRead(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := up.put(base+".txt", f, info.Size(), "text/plain; charset=utf-8"); err != nil {
+		return err
+	}
+	js := s.json()
+	if err := up.put(base+".summary.json", strings.NewReader(js), int64(len(js)), "application/json"); err != nil {
+		return err
+	}
+	if keep > 0 {
+		return applyRetention(up, keep)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package md5summer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcomes of verifying a single file.
+const (
+	verdictOK      = "OK"
+	verdictFailed  = "FAILED"
+	verdictMissing = "MISSING"
+	verdictError   = "ERROR"
+)
+
+// verification is the outcome of checking one file against an expected
+// digest.
+type verification struct {
+	path    string
+	verdict string
+	// set for verdictError
+	err error
+	// assigned by a policy, empty without one
+	severity string
+	// the digest the file should have and, once read, the one it has
+	expected, actual []byte
+	// context added after the fact, e.g. by correlateFSErrors
+	note string
+	// number of bytes read to verify the file
+	size int64
+}
+
+func (v verification) String() string {
+	s := v.path + ": " + v.verdict
+	if v.err != nil {
+		s += fmt.Sprintf(" %v", v.err)
+	}
+	if v.note != "" {
+		s += " (" + v.note + ")"
+	}
+	return s
+}
+
+// verifier checks files against expected digests on a pool of workers.
+type verifier struct {
+	lk       sync.Mutex
+	results  []verification
+	wg       sync.WaitGroup
+	throttle throttle
+	// stops the checks, those not done yet end in verdictError
+	ctx    context.Context
+	cancel context.CancelFunc
+	// where the files are read from
+	source Source
+	// if set, the first outcome it returns true for cancels the checks
+	// not done yet and is the only one wait returns, for -fail-fast
+	stop func(verification) bool
+	// the outcome that stopped the checks
+	first *verification
+	// if set, counts the files and bytes checked
+	status *status
+}
+
+func newVerifier() *verifier {
+	return newContextVerifier(context.Background(), numWorkers)
+}
+
+// newContextVerifier returns a verifier reading workers files at a time
+// until ctx is cancelled.
+func newContextVerifier(ctx context.Context, workers int) *verifier {
+	ctx, cancel := context.WithCancel(ctx)
+	return &verifier{throttle: newThrottle(workers), ctx: ctx, cancel: cancel, source: localSource{}}
+}
+
+// check verifies the file at path in the background, unless the checks
+// were stopped.
+func (v *verifier) check(path, algo string, expected []byte) {
+	v.throttle.wait()
+	if v.stopped() {
+		v.throttle.ready()
+		return
+	}
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		defer v.throttle.ready()
+		v.record(verifyFile(v.ctx, v.source, path, algo, expected))
+	}()
+}
+
+// record adds an outcome that was determined without reading a file.
+func (v *verifier) record(res verification) {
+	v.lk.Lock()
+	v.results = append(v.results, res)
+	if v.status != nil {
+		v.status.files.Add(1)
+		v.status.bytes.Add(res.size)
+	}
+	if v.stop != nil && v.first == nil && v.stop(res) {
+		v.first = &res
+		v.cancel()
+	}
+	v.lk.Unlock()
+}
+
+// stopped reports whether an outcome stopped the checks.
+func (v *verifier) stopped() bool {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	return v.first != nil
+}
+
+// wait waits for all checks to finish and returns their outcomes ordered
+// by path, or only the one that stopped them.
+func (v *verifier) wait() []verification {
+	v.wg.Wait()
+	v.cancel()
+	if v.first != nil {
+		log.Printf("stopped at the first difference")
+		return []verification{*v.first}
+	}
+	sort.Slice(v.results, func(i, j int) bool { return v.results[i].path < v.results[j].path })
+	return v.results
+}
+
+// verifyFile checks the file at path, read from src, against the expected
+// digest.
+func verifyFile(ctx context.Context, src Source, path, algo string, expected []byte) verification {
+	h, err := newHash(algo)
+	if err != nil {
+		return verification{path: path, verdict: verdictError, err: err, expected: expected}
+	}
+	f, err := src.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return verification{path: path, verdict: verdictMissing, expected: expected}
+	}
+	if err != nil {
+		return verification{path: path, verdict: verdictError, err: err, expected: expected}
+	}
+	defer f.Close()
+	n, err := io.Copy(h, contextReader{ctx, f})
+	if err != nil {
+		return verification{path: path, verdict: verdictError, err: err, expected: expected, size: n}
+	}
+	actual := h.Sum(nil)
+	if !bytes.Equal(actual, expected) {
+		return verification{path: path, verdict: verdictFailed, expected: expected, actual: actual, size: n}
+	}
+	return verification{path: path, verdict: verdictOK, expected: expected, actual: actual, size: n}
+}
+
+// tally counts verifications by verdict.
+func tally(results []verification) map[string]int {
+	counts := make(map[string]int)
+	for _, v := range results {
+		counts[v.verdict]++
+	}
+	return counts
+}
+
+// verificationExitCode maps the outcome of a verification run onto the
+// exit statuses shared by all comparison modes.
+func verificationExitCode(counts map[string]int) int {
+	switch {
+	case counts[verdictError] > 0:
+		return exitError
+	case counts[verdictFailed] > 0 || counts[verdictMissing] > 0 || counts[verdictExtra] > 0:
+		return exitDifferent
+	}
+	return exitIdentical
+}
+
+// reports receives the outcome of verifications, stdout unless Main
+// redacts it.
+var reports io.Writer = os.Stdout
+
+// reportVerifications prints the outcome of every verification to
+// reports and the totals to stderr, and exits with the matching status.
+func reportVerifications(results []verification) {
+	for _, v := range results {
+		fmt.Fprintln(reports, v.String())
+	}
+	exitVerified(results)
+}
+
+// exitVerified prints the totals of a verification to stderr and exits
+// with the matching status.
+func exitVerified(results []verification) {
+	counts := tally(results)
+	totals := fmt.Sprintf("%d OK, %d FAILED, %d MISSING, %d ERROR", counts[verdictOK], counts[verdictFailed], counts[verdictMissing], counts[verdictError])
+	if counts[verdictExtra] > 0 {
+		totals += fmt.Sprintf(", %d EXTRA", counts[verdictExtra])
+	}
+	log.Print(totals)
+	os.Exit(verifiedExitCode(results))
+}
+
+// verifiedExitCode returns the exit status of a verification.
+func verifiedExitCode(results []verification) int {
+	// informational results are reported but don't fail the run
+	var counted []verification
+	for _, v := range results {
+		if v.severity != severityInfo {
+			counted = append(counted, v)
+		}
+	}
+	return verificationExitCode(tally(counted))
+}
+
+// VerifyOptions configure Verify.
+type VerifyOptions struct {
+	// number of files read at a time, numWorkers if 0
+	Workers int
+	// where the files are read from, the local filesystem if nil
+	Source Source
+	// if set, the files are read from FS instead of Source, relative to
+	// root, which fs.ValidPath must accept
+	FS fs.FS
+	// if set, called with the progress of the verification twice a
+	// second and once it is over, the total being the files of the
+	// manifest
+	Progress func(Progress)
+	// the clock Progress.Elapsed is measured by, time.Now if nil. It may
+	// be called from several goroutines at once.
+	Now func() time.Time
+	// if set, faults are injected into the reads of the files, as
+	// ChaosSource does, seeded by its Seed so that runs are repeatable
+	Chaos *ChaosOptions
+}
+
+// FileResult is the outcome of verifying one file.
+type FileResult struct {
+	Path string
+	// VerdictOK, VerdictFailed, VerdictMissing or VerdictError
+	Verdict string
+	// the digest the file should have and, once read, the one it has
+	Expected, Actual []byte
+	// why the file couldn't be verified, for ERROR
+	Err error
+}
+
+// Verdicts of a FileResult, also the keys of Report.Counts.
+const (
+	VerdictOK      = verdictOK
+	VerdictFailed  = verdictFailed
+	VerdictMissing = verdictMissing
+	VerdictError   = verdictError
+)
+
+// Report is the outcome of Verify.
+type Report struct {
+	// ordered by path
+	Files []FileResult
+	// number of files by verdict
+	Counts map[string]int
+}
+
+// OK reports whether every file was there and matched.
+func (r Report) OK() bool {
+	return verificationExitCode(r.Counts) == exitIdentical
+}
+
+// Verify checks the files of m against their digests, for programs that
+// want the outcome rather than the output of a verifying run. Relative
+// paths in m are relative to root. Once ctx is cancelled the files not
+// done yet end in ERROR and the error of ctx is returned along with them.
+func Verify(ctx context.Context, m *Manifest, root string, opts VerifyOptions) (Report, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = numWorkers
+	}
+	algo, sums := m.snapshot()
+	v := newContextVerifier(ctx, workers)
+	join := filepath.Join
+	if opts.Source != nil {
+		v.source = opts.Source
+	}
+	if opts.FS != nil {
+		v.source = fsSource{opts.FS}
+		join = path.Join
+	}
+	if opts.Chaos != nil {
+		v.source = ChaosSource(v.source, *opts.Chaos)
+	}
+	stopProgress := func() {}
+	if opts.Progress != nil {
+		now := opts.Now
+		if now == nil {
+			now = time.Now
+		}
+		v.status = newStatus()
+		v.status.started = now()
+		stopProgress = reportProgress(v.status, int64(len(sums)), 0, now, opts.Progress)
+	}
+	for path, sum := range sums {
+		if !filepath.IsAbs(path) {
+			path = join(root, path)
+		}
+		v.check(path, algo, sum)
+	}
+	results := v.wait()
+	stopProgress()
+	r := Report{Files: make([]FileResult, len(results)), Counts: tally(results)}
+	for ii, res := range results {
+		r.Files[ii] = FileResult{res.path, res.verdict, res.expected, res.actual, res.err}
+	}
+	return r, ctx.Err()
+}
@@ -0,0 +1,108 @@
+package md5summer
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes a file for every name with its name as contents, and
+// returns the manifest of them.
+func writeTree(t *testing.T, root string, names []string) string {
+	t.Helper()
+	var m strings.Builder
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := md5.Sum([]byte(name))
+		fmt.Fprintln(&m, gnuLine(sum[:], name))
+	}
+	return m.String()
+}
+
+func TestVerifyManifestFailFast(t *testing.T) {
+	root := t.TempDir()
+	var names []string
+	for ii := range 50 {
+		names = append(names, fmt.Sprintf("f%02d", ii))
+	}
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	if err := os.WriteFile(manifest, []byte(writeTree(t, root, names)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "f17"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := verifyManifest(manifest, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := tally(results); counts[verdictOK] != 49 || counts[verdictFailed] != 1 {
+		t.Errorf("without stop got %v", counts)
+	}
+
+	results, err = verifyManifest(manifest, root, differs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].verdict != verdictFailed || results[0].path != filepath.Join(root, "f17") {
+		t.Errorf("with stop got %v, want only f17 FAILED", results)
+	}
+	if code := verifiedExitCode(results); code != exitDifferent {
+		t.Errorf("exit status %d, want %d", code, exitDifferent)
+	}
+}
+
+func TestCompareTreesFailFast(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeTree(t, a, []string{"one", "two", "three"})
+	writeTree(t, b, []string{"one", "two", "three", "four"})
+	if err := os.WriteFile(filepath.Join(b, "two"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stop func(verification) bool
+		want []string
+	}{
+		{nil, []string{"four: EXTRA", "one: OK", "three: OK", "two: FAILED"}},
+		{differs, []string{"two: FAILED"}},
+		// the extra file is only found once both trees are walked
+		{func(v verification) bool { return v.verdict == verdictExtra }, []string{"four: EXTRA"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, v := range compareTrees(a, b, walkOptions{}, false, test.stop) {
+			got = append(got, v.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestVerifyManifestNewlineInPath(t *testing.T) {
+	root := t.TempDir()
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	// a plain manifest can't tell a newline in a path from the end of
+	// the record
+	sum := md5.Sum([]byte("x"))
+	record := (&checksum{filepath: "a\nb", sum: sum[:], algo: "md5"}).String()
+	if err := os.WriteFile(manifest, []byte(writeTree(t, root, []string{"c"})+record+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := verifyManifest(manifest, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := tally(results); counts[verdictOK] != 1 || counts[verdictError] != 1 {
+		t.Errorf("got %v", results)
+	}
+	if code := verifiedExitCode(results); code != exitError {
+		t.Errorf("exit status %d, want %d", code, exitError)
+	}
+}
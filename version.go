@@ -0,0 +1,94 @@
+package md5summer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+)
+
+// version is set at link time with -ldflags "-X github.com/gpaul/md5summer.version=..."
+var version = "devel"
+
+// buildInfo describes this binary and the capabilities compiled into it.
+type buildInfo struct {
+	Version          string   `json:"version"`
+	Commit           string   `json:"commit,omitempty"`
+	GoVersion        string   `json:"go_version"`
+	Platform         string   `json:"platform"`
+	Algorithms       []string `json:"algorithms"`
+	Backends         []string `json:"backends"`
+	ManifestVersions []int    `json:"manifest_versions"`
+}
+
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:          version,
+		GoVersion:        runtime.Version(),
+		Platform:         runtime.GOOS + "/" + runtime.GOARCH,
+		Algorithms:       algorithms(),
+		Backends:         slices.Clone(remoteSchemes),
+		ManifestVersions: []int{manifestVersion},
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	if fadviseSupported {
+		info.Backends = append(info.Backends, "fadvise")
+	}
+	return info
+}
+
+// algorithms returns the names of the hash algorithms -algo takes: those
+// of hashes, their keyed variants and, with SIZE standing for the chunk
+// size, their chunked ones.
+func algorithms() []string {
+	bases := slices.Sorted(maps.Keys(hashes))
+	names := slices.Clone(bases)
+	for _, base := range bases {
+		if _, ok := hmacAlgo("hmac-" + base); ok {
+			names = append(names, "hmac-"+base)
+		}
+	}
+	for _, base := range bases {
+		names = append(names, base+"/SIZE")
+	}
+	return names
+}
+
+// runVersion implements the version subcommand.
+func runVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print build information as JSON")
+	fs.Parse(args)
+
+	if err := currentBuildInfo().writeTo(os.Stdout, *asJSON); err != nil {
+		panic(fmt.Errorf("could not write version: %v", err))
+	}
+}
+
+func (info buildInfo) writeTo(w io.Writer, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	_, err := fmt.Fprintf(w, "md5summer %s (commit %s, %s, %s)\nalgorithms: %s\nbackends: %s\n",
+		info.Version, commit, info.GoVersion, info.Platform,
+		strings.Join(info.Algorithms, ", "), strings.Join(info.Backends, ", "))
+	return err
+}
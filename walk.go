@@ -1,31 +1,604 @@
 // example application for blog post: http://gpaul.github.io/blog/2014/12/24/clean-shutdown-example/
-package main
+
+package md5summer
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/md5"
 	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-func main() {
+// Main runs the md5summer command with the arguments in os.Args and
+// exits; cmd/md5summer is nothing more.
+func Main() {
+	// stdout carries nothing but the manifest so it can be piped safely,
+	// every diagnostic goes through the log package to stderr
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	log.SetPrefix("md5summer: ")
+
 	var rootdir string
-	flag.StringVar(&rootdir, "dir", ".", "directory to calculate checksums of")
+	var sortKey string
+	var sortNatural, noSort, lowMemory bool
+	var groupBy string
+	var format string
+	var launchdLabel string
+	var firstFrom string
+	var perDirManifest string
+	var verifyJSON string
+	var uploadTo string
+	var keepRuns int
+	var since string
+	var accessLogPath string
+	var redactPatterns patternList
+	var redactMask bool
+	var encrypt string
+	var splitOutput string
+	var splitEntries int
+	var subtreeJobs int
+	var onMismatch string
+	var dryRun bool
+	var execOn patternList
+	var rescueMapFiles patternList
+	var include, exclude patternList
+	var followSymlinks, hashLinkText bool
+	var cachePath string
+	var compareWith string
+	var compat string
+	var chaosSpec string
+	var showProgress bool
+	var ifChanged string
+	var keepGoing, failFast bool
+	var watchInterval time.Duration
+	var onDuplicate string
+	var caseCollisions bool
+	var outputPath string
+	var chunkSize int64
+	var withTreeDigests bool
+	var stallTimeout time.Duration
+	var skipStalled bool
+	var summaryJSON string
+	var doubleRead bool
+	var bestOf int
+	var filesFrom string
+	var sequential bool
+	var hmacKeyFile string
+	var images patternList
+	var imagePartitions bool
+	var imageExtent int64
+	var imageStatePath string
+	var signKeyFile, pubkeyFile string
+	var signDetached, verifySig bool
+	var dupes bool
+	var dupesMinSize int64
+	var oneFileSystem bool
+	var appendOutput bool
+	var policyFile string
+	var verifyPath string
+	var algo string
+	var noTrustRemote bool
+	var sorted bool
+	var sortBuffer int
+	var suppressFile string
+	var withHeader, withTrailer, summaryOnly, useTUI, notifyDesktop bool
+	var verifySidecarFiles, fsErrors bool
+	flag.StringVar(&rootdir, "dir", ".", "directory to calculate checksums of, or hdfs://[user@]namenode:port/path to read one through WebHDFS, drive://path or onedrive://path with an OAuth token in MD5SUMMER_DRIVE_TOKEN or MD5SUMMER_ONEDRIVE_TOKEN, or s3://bucket/prefix or gs://bucket/prefix with the credentials of -upload")
+	flag.BoolVar(&withHeader, "header", false, "write a metadata header at the top of the output")
+	flag.BoolVar(&withTrailer, "trailer", false, "write the entry count and a digest of the records at the end of the output")
+	flag.StringVar(&sortKey, "sort", "path", "order of the output: path, size, mtime or digest")
+	flag.BoolVar(&sortNatural, "sort-natural", false, "compare runs of digits in paths numerically, so file2 sorts before file10")
+	flag.BoolVar(&noSort, "no-sort", false, "skip sorting and write each checksum as soon as it is calculated")
+	flag.BoolVar(&sorted, "sorted", true, "sort the output; -sorted=false is the same as -no-sort")
+	flag.IntVar(&sortBuffer, "sort-buffer", 0, "sort with bounded memory, in runs of this many records spilled to temporary files and merged, 0 sorts in memory")
+	flag.StringVar(&groupBy, "group-by", "", "set to 'hash' to write each distinct digest followed by the paths that have it")
+	flag.BoolVar(&summaryOnly, "summary-only", false, "print only the totals of the run instead of a checksum per file")
+	flag.BoolVar(&dupes, "dupes", false, "print the sets of files with identical contents and the bytes removing the copies would free instead of a checksum per file")
+	flag.Int64Var(&dupesMinSize, "dupes-min-size", 1, "with -dupes, leave out files smaller than this many bytes")
+	flag.StringVar(&launchdLabel, "launchd-plist", "", "print a macOS launchd job with this label that runs the other flags nightly, then exit")
+	flag.StringVar(&outputPath, "output", "", "write the manifest to this file instead of standard output, replacing it only once the manifest is complete")
+	flag.BoolVar(&appendOutput, "append", false, "with -output, add the records of files not yet in the manifest to it instead of replacing it")
+	flag.Int64Var(&chunkSize, "chunk-size", 0, "hash files larger than this many bytes in chunks of this size in parallel, recording the digest of the chunk digests under the algorithm ALGO/SIZE; 0 hashes every file in one piece")
+	flag.BoolVar(&withTreeDigests, "tree-digests", false, "also write a '#tree DIGEST DIR/' line for every directory, derived from the names and digests of what is in it, so whole subtrees can be compared by one digest")
+	flag.DurationVar(&stallTimeout, "stall-timeout", 2*time.Minute, "report files whose reads made no progress for this long, or that take far longer than their size suggests; 0 disables the watchdog")
+	flag.BoolVar(&skipStalled, "skip-stalled", false, "skip the files -stall-timeout reports, leaving them out of the output")
+	flag.StringVar(&summaryJSON, "summary-json", "", "write the number of files, bytes, errors, the time taken, throughput and exit status of the run as JSON to this file, or to stderr for '-'")
+	flag.BoolVar(&doubleRead, "double-read", false, "read every file twice, dropping it from the page cache in between, and fail files whose two digests differ, to catch flaky memory, controllers or disks")
+	flag.IntVar(&bestOf, "best-of", 0, "read every file this many times, dropping it from the page cache in between, and record the digest more than half of the reads agree on, noting disagreements; for rescuing failing media")
+	flag.StringVar(&filesFrom, "files-from", "", "checksum only the files listed in this file, or on stdin for '-', instead of walking -dir; paths are separated by NUL bytes or newlines, relative ones are relative to -dir")
+	flag.StringVar(&hmacKeyFile, "hmac-key-file", "", "calculate HMACs of the files with the secret key in this file instead of plain digests, recorded as hmac-ALGO, so whoever changes files can't forge the manifest to match without the key; verifying needs the same key")
+	flag.Var(&images, "image", "also checksum this disk image or block device as a whole, recorded like a file, e.g. next to a -dir where its filesystem is mounted; may be repeated")
+	flag.BoolVar(&imagePartitions, "image-partitions", false, "with -image, also write a '#partition N OFFSET+SIZE DIGEST IMAGE' line for every partition in the MBR or GPT of each image, hashed in the same read")
+	flag.StringVar(&signKeyFile, "sign-key", "", "sign the manifest with the Ed25519 key in this file, made by the keygen subcommand, in a '#signature' line at its end")
+	flag.BoolVar(&signDetached, "sign-detached", false, "with -sign-key and -output, write the signature to PATH.sig instead of into the manifest")
+	flag.BoolVar(&verifySig, "verify-sig", false, "with -verify, check the signature of the manifest, its own or the one in MANIFEST.sig, with -pubkey before verifying the tree")
+	flag.StringVar(&pubkeyFile, "pubkey", "", "file holding the public key -verify-sig checks with, as printed by the pubkey subcommand")
+	flag.Int64Var(&imageExtent, "image-extent", 0, "with -image, also write an '#extent OFFSET+SIZE DIGEST IMAGE' line for every extent of this many bytes of each image, so a damaged region can be told from the rest")
+	flag.StringVar(&imageStatePath, "image-state", "", "with -image, save how far each image got to this file now and then and when interrupted, and resume from it, so a device taking hours to read needn't start over")
+	flag.BoolVar(&sequential, "sequential", false, "read one file at a time, in the order the files are stored in on the medium where that is known, else in path order, with large reads; for tapes and LTFS, where parallel and random access are slow")
+	flag.BoolVar(&oneFileSystem, "one-file-system", false, "skip directories and files on other filesystems than -dir, such as /proc or network and bind mounts below it")
+	flag.StringVar(&onDuplicate, "on-duplicate", "warn", "what to do when a path is checksummed more than once: merge drops repeats with the same digest, warn keeps the first record and logs, error fails the run; warn by default, except with -no-sort or -sort-buffer, which only check when asked since it keeps every path in memory")
+	flag.BoolVar(&caseCollisions, "case-collisions", false, "report paths that differ only by case also with -no-sort or -sort-buffer, which don't by default since it keeps every path in memory; sorted runs always report them")
+	flag.BoolVar(&lowMemory, "low-memory", false, "keep memory use small on constrained devices, implies -no-sort")
+	flag.BoolVar(&useTUI, "tui", false, "show a live status screen on the terminal, with keys to pause, skip the current file or abort")
+	flag.BoolVar(&showProgress, "progress", false, "count the files and bytes first, then show a progress bar with the throughput and the time left on stderr")
+	flag.BoolVar(&notifyDesktop, "notify-desktop", false, "show a desktop notification when the run finishes or fails")
+	flag.StringVar(&firstFrom, "first-from", "", "file listing paths, one per line, to checksum before the rest of the tree")
+	flag.StringVar(&perDirManifest, "per-dir-manifest", "", "also write a manifest with this name into every directory, covering the files directly in it in the format of md5sum; -verify-sidecars with the same flag verifies them")
+	flag.BoolVar(&verifySidecarFiles, "verify-sidecars", false, "verify files against the MD5SUMS, sha256sums, *.md5, *.sha256, ... files found in the tree instead of writing a manifest; with -per-dir-manifest, against the manifests of that name too")
+	flag.StringVar(&format, "format", "plain", "format of the records: plain, certutil, csv, json, ndjson, gnu or gnu-binary")
+	flag.StringVar(&compat, "compat", "", "write records exactly like another tool: gnu for md5sum and its siblings, gnu-binary for them with -b; -verify reads both")
+	flag.StringVar(&algo, "algo", "md5", "hash algorithm: md5, sha1, sha256, sha512, blake2b, xxhash or crc32c, or several separated by commas to calculate them all from one read; records carry every digest and the first is the one compared")
+	flag.BoolVar(&noTrustRemote, "no-trust-remote", false, "read every file of a remote -dir instead of using the digests its provider reports")
+	flag.StringVar(&verifyPath, "verify", "", "verify the tree against this manifest, written by md5summer or md5sum, instead of writing one; relative paths are below -dir")
+	flag.StringVar(&verifyJSON, "files-from-json", "", "verify the tree against the paths and digests in this JSON file, e.g. exported from a backup catalog with 'rclone lsjson -R --hash', whose directory entries are skipped")
+	flag.BoolVar(&fsErrors, "fs-errors", false, "when verifying, tell failures ZFS or Btrfs report as corrupt apart from changed files")
+	flag.StringVar(&uploadTo, "upload", "", "upload the manifest and a JSON summary to s3://bucket/prefix/, gs://bucket/prefix/ or az://account/container/prefix/")
+	flag.IntVar(&keepRuns, "keep", 0, "with -upload, keep only this many runs at the destination plus the first run of every month, 0 keeps all")
+	flag.StringVar(&since, "since", "", "write only the records that are new or changed compared to this earlier manifest, whose relative paths are relative to -dir")
+	flag.StringVar(&accessLogPath, "access-log", "", "append a line for every file opened, read, written or removed and every directory listed to this file")
+	flag.Var(&redactPatterns, "redact-pattern", "replace path components matching this regular expression with a hash in reports and logs, may be repeated; manifests keep full paths")
+	flag.BoolVar(&redactMask, "redact-mask", false, "replace redacted path components with a fixed marker instead of a hash")
+	flag.StringVar(&splitOutput, "split-output", "", "also write one manifest per top-level directory into this directory, so each owner gets only their slice")
+	flag.IntVar(&splitEntries, "split-entries", 0, "with -split-output, split into manifests of this many records each instead of by top-level directory")
+	flag.StringVar(&onMismatch, "on-mismatch", "", "when verifying, move files that fail out of the way: quarantine=DIR moves them below DIR, rename-suffix=SUFFIX renames them")
+	flag.BoolVar(&dryRun, "dry-run", false, "with -on-mismatch, only report what would be moved")
+	flag.Var(&execOn, "exec-on", "when verifying, run a command on an event, given as EVENT=COMMAND with EVENT mismatch, error or complete, e.g. -exec-on 'mismatch=logger \"{path} failed\"'; COMMAND is split into words at spaces, quoted as in a shell, and run without one; {path}, {expected}, {actual}, {verdict} and {severity} in it are replaced, may be repeated")
+	flag.StringVar(&policyFile, "policy", "", "when verifying, assign severities to failures by the 'PATTERN SEVERITY [alert]' rules in this file; ignore drops them, info doesn't fail the run, alert shows a desktop notification")
+	flag.StringVar(&suppressFile, "suppress", "", "leave out differences in known-churn files, by the 'PATTERN EXPIRY REASON' rules in this file, when verifying or with -since")
+	flag.IntVar(&subtreeJobs, "subtree-jobs", 0, "walk this many top-level directories in parallel, each in a walk of its own sharing the same workers, 0 walks the tree in one")
+	flag.StringVar(&encrypt, "encrypt", "", "encrypt the manifest with AES-256-GCM using the key in aes:keyfile, see the keygen and decrypt subcommands")
+	flag.Var(&rescueMapFiles, "ddrescue-map", "the ddrescue map of an image below -dir, given as IMAGE=MAPFILE: write a '#piece' digest for every block ddrescue read and an '#unrecovered' line for every block it didn't, and note unrecovered blocks when verifying; may be repeated")
+	flag.Var(&include, "include", "only checksum files whose path below -dir matches this glob, '**' matching any number of directories and patterns without a slash matching the base name, may be repeated")
+	flag.Var(&exclude, "exclude", "skip files and directories whose path below -dir matches this glob, like -include, may be repeated")
+	flag.IntVar(&numWorkers, "workers", numWorkers, "number of files to read at a time, more suit fast SSD arrays and fewer slow network filesystems")
+	flag.BoolVar(&failFast, "fail-fast", false, "when verifying or with -compare, stop at the first file that differs or can't be read, print it and exit 1, or 2 if it couldn't be read")
+	flag.BoolVar(&keepGoing, "keep-going", false, "don't stop at files or directories that can't be read, note them in the output and list them at the end, exiting with status 2")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "follow symbolic links to files and directories, skipping links that lead back to a directory above them")
+	flag.BoolVar(&hashLinkText, "hash-link-target-path", false, "checksum symbolic links by the path they hold instead of the file they point to")
+	flag.StringVar(&cachePath, "cache", "", "file keeping the size, modification time and digest of every file, files unchanged since an earlier run with the same file aren't read again")
+	flag.StringVar(&ifChanged, "if-changed", "", "with -cache, write nothing and exit if the files, by their sizes and modification times, still match this earlier manifest")
+	flag.StringVar(&compareWith, "compare", "", "compare -dir with this directory, local or remote, and report files that differ, only in -dir (MISSING) or only here (EXTRA); -format json prints the outcome as JSON")
+	flag.DurationVar(&watchInterval, "watch", 0, "keep running after the manifest is written, checking the tree this often and printing a record for every file created or changed and a '#removed PATH' line for every file gone")
+	flag.StringVar(&chaosSpec, "chaos", "", "inject faults into the reads of files, given as p=PROBABILITY[,seed=N][,delay=DURATION]: reads fail, stall for delay or return changed data")
+
+	// subcommands are dispatched once all flags are defined, so that
+	// completion and man pages can be generated from them
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "version":
+			runVersion(os.Args[2:])
+			return
+		case "completion":
+			runCompletion(os.Args[2:])
+			return
+		case "man":
+			runMan(os.Args[2:])
+			return
+		case "estimate":
+			runEstimate(os.Args[2:])
+			return
+		case "copy":
+			runCopy(os.Args[2:])
+			return
+		case "move":
+			runMove(os.Args[2:])
+			return
+		case "compare":
+			runCompare(os.Args[2:])
+			return
+		case "serve-files":
+			runServeFiles(os.Args[2:])
+			return
+		case "fetch":
+			runFetch(os.Args[2:])
+			return
+		case "fmt":
+			runFmt(os.Args[2:])
+			return
+		case "monitor":
+			runMonitor(os.Args[2:])
+			return
+		case "keygen":
+			runKeygen(os.Args[2:])
+			return
+		case "decrypt":
+			runDecrypt(os.Args[2:])
+			return
+		case "pubkey":
+			runPubkey(os.Args[2:])
+			return
+		case "verify-disc":
+			runVerifyDisc(os.Args[2:])
+			return
+		}
+	}
+	flag.Usage = usage
 	flag.Parse()
+	started := time.Now()
+
+	if !sorted {
+		noSort = true
+	}
+	if lowMemory {
+		// collecting and sorting every checksum is what grows with the
+		// tree, streaming keeps the footprint flat. A lower GC target
+		// trades some CPU for a smaller heap.
+		noSort = true
+		debug.SetGCPercent(20)
+	}
+
+	less, err := sortOrder(sortKey, sortNatural)
+	if err != nil {
+		panic(err)
+	}
+	if groupBy != "" && groupBy != "hash" {
+		panic(fmt.Errorf("unknown -group-by value '%s', expected 'hash'", groupBy))
+	}
+	var signKey ed25519.PrivateKey
+	if signKeyFile != "" {
+		if signKey, err = loadSigningKey(signKeyFile); err != nil {
+			panic(fmt.Errorf("invalid -sign-key: %v", err))
+		}
+	}
+	var pubkey ed25519.PublicKey
+	if pubkeyFile != "" {
+		if pubkey, err = loadPublicKey(pubkeyFile); err != nil {
+			panic(fmt.Errorf("invalid -pubkey: %v", err))
+		}
+	}
+	var encryptKey []byte
+	if encrypt != "" {
+		if encryptKey, err = parseEncryptFlag(encrypt); err != nil {
+			panic(fmt.Errorf("invalid -encrypt: %v", err))
+		}
+	}
+	if splitEntries < 0 {
+		panic(fmt.Errorf("-split-entries must not be negative"))
+	}
+	if splitEntries > 0 && splitOutput == "" {
+		panic(fmt.Errorf("-split-entries only applies to -split-output"))
+	}
+	if chunkSize < 0 {
+		panic(fmt.Errorf("-chunk-size must not be negative"))
+	}
+	if hmacKeyFile != "" {
+		if hmacKey, err = readHMACKey(hmacKeyFile); err != nil {
+			panic(fmt.Errorf("cannot read '%s': %v", hmacKeyFile, err))
+		}
+		if chunkSize > 0 {
+			panic(fmt.Errorf("-hmac-key-file cannot be combined with -chunk-size"))
+		}
+	}
+	algos := strings.Split(algo, ",")
+	for i, a := range algos {
+		if hmacKeyFile != "" {
+			a = "hmac-" + a
+			algos[i] = a
+		}
+		if chunkSize > 0 {
+			a = fmt.Sprintf("%s/%d", a, chunkSize)
+			algos[i] = a
+		}
+		if _, err := newHash(a); err != nil {
+			panic(fmt.Errorf("invalid -algo: %v", err))
+		}
+		if slices.Contains(algos[:i], a) {
+			panic(fmt.Errorf("invalid -algo: '%s' is given twice", a))
+		}
+	}
+	// the algorithm records are compared by, kept apart from the flag,
+	// which the header and -launchd-plist report as given
+	primary := algos[0]
+	verifying := verifySidecarFiles || verifyJSON != "" || verifyPath != ""
+	var mismatch *mismatchAction
+	if onMismatch != "" {
+		if mismatch, err = parseMismatchAction(onMismatch); err != nil {
+			panic(fmt.Errorf("invalid -on-mismatch: %v", err))
+		}
+	}
+	if dryRun && mismatch == nil {
+		panic(fmt.Errorf("-dry-run only applies to -on-mismatch"))
+	}
+	hooks, err := parseExecHooks(execOn)
+	if err != nil {
+		panic(fmt.Errorf("invalid -exec-on: %v", err))
+	}
+	if len(hooks) > 0 && !verifying {
+		panic(fmt.Errorf("-exec-on only applies when verifying"))
+	}
+	var pol policy
+	if policyFile != "" {
+		if !verifying {
+			panic(fmt.Errorf("-policy only applies when verifying"))
+		}
+		if pol, err = readPolicy(policyFile); err != nil {
+			panic(fmt.Errorf("cannot read policy '%s': %v", policyFile, err))
+		}
+	}
+	if suppressFile != "" && !verifying && since == "" {
+		panic(fmt.Errorf("-suppress only applies when verifying or with -since"))
+	}
+	if failFast && !verifying && compareWith == "" {
+		panic(fmt.Errorf("-fail-fast only applies when verifying or with -compare"))
+	}
+	if compareWith != "" && verifying {
+		panic(fmt.Errorf("-compare cannot be combined with verifying"))
+	}
+	if compareWith != "" && format != "plain" && format != "json" {
+		panic(fmt.Errorf("-compare can only be combined with -format plain or json"))
+	}
+	if ifChanged != "" && (cachePath == "" || verifying) {
+		panic(fmt.Errorf("-if-changed needs -cache and doesn't apply when verifying"))
+	}
+	if showProgress && useTUI {
+		panic(fmt.Errorf("-progress cannot be combined with -tui"))
+	}
+	if numWorkers < 1 {
+		panic(fmt.Errorf("-workers must be at least 1"))
+	}
+	if sequential && (subtreeJobs > 0 || firstFrom != "") {
+		panic(fmt.Errorf("-sequential cannot be combined with -subtree-jobs or -first-from"))
+	}
+	if sequential {
+		// verifying reads the files one at a time, too
+		numWorkers = 1
+	}
+	if subtreeJobs < 0 {
+		panic(fmt.Errorf("-subtree-jobs must not be negative"))
+	}
+	// every worker holds a file open, too many of them end in a storm of
+	// "too many open files" errors instead of a faster run
+	if limit, ok := openFileLimit(); ok {
+		allowed := int(min(limit, math.MaxInt32)) - reservedFiles - subtreeJobs
+		if numWorkers > allowed {
+			allowed = max(allowed, 1)
+			log.Printf("lowering -workers from %d to %d to stay below the limit of %d open files", numWorkers, allowed, limit)
+			numWorkers = allowed
+		}
+	}
+	if keepRuns > 0 && uploadTo == "" {
+		panic(fmt.Errorf("-keep only applies to runs uploaded with -upload"))
+	}
+	if compat != "" {
+		if compat != "gnu" && compat != "gnu-binary" {
+			panic(fmt.Errorf("unknown -compat '%s', expected gnu or gnu-binary", compat))
+		}
+		if format != "plain" {
+			panic(fmt.Errorf("-compat cannot be combined with -format"))
+		}
+		format = compat
+	}
+	recordFormat, ok := recordFormats[format]
+	if !ok {
+		panic(fmt.Errorf("unknown -format '%s', expected one of %s", format, formatNames()))
+	}
+	if (withHeader || withTrailer) && !recordFormat.comments {
+		panic(fmt.Errorf("-header and -trailer cannot be combined with -format %s", format))
+	}
+	// catching repeated paths and paths differing by case keeps every
+	// path in memory, which sorting in memory does anyway but the
+	// streaming runs are there to avoid
+	streaming := noSort || sortBuffer > 0
+	if lowMemory && (onDuplicate != "" || caseCollisions) {
+		panic(fmt.Errorf("-on-duplicate and -case-collisions keep every path in memory and cannot be combined with -low-memory"))
+	}
+	if onDuplicate == "" && !streaming {
+		onDuplicate = "warn"
+	}
+	var dups *duplicates
+	if onDuplicate != "" {
+		if dups, err = newDuplicates(onDuplicate); err != nil {
+			panic(fmt.Errorf("invalid -on-duplicate: %v", err))
+		}
+	}
+	var folds *caseFolds
+	if caseCollisions || !streaming {
+		folds = newCaseFolds()
+	}
+	if appendOutput && outputPath == "" {
+		panic(fmt.Errorf("-append needs -output"))
+	}
+	if outputPath != "" && (verifying || compareWith != "" || summaryOnly || watchInterval > 0) {
+		panic(fmt.Errorf("-output cannot be combined with verifying, -compare, -summary-only or -watch"))
+	}
+	if appendOutput && ((format != "plain" && format != "gnu" && format != "gnu-binary") || withHeader || withTrailer || encrypt != "" || groupBy != "") {
+		panic(fmt.Errorf("-append only adds records, it needs -format plain, gnu or gnu-binary and cannot be combined with -header, -trailer, -encrypt or -group-by"))
+	}
+	if watchInterval < 0 {
+		panic(fmt.Errorf("-watch must not be negative"))
+	}
+	if dupes && (verifying || compareWith != "" || summaryOnly || outputPath != "" || watchInterval > 0 || groupBy != "") {
+		panic(fmt.Errorf("-dupes cannot be combined with verifying, -compare, -summary-only, -output, -watch or -group-by"))
+	}
+	if dupesMinSize < 0 {
+		panic(fmt.Errorf("-dupes-min-size must not be negative"))
+	}
+	if watchInterval > 0 && !recordFormat.comments {
+		panic(fmt.Errorf("-watch cannot be combined with -format %s", format))
+	}
+	if watchInterval > 0 && (verifying || compareWith != "" || summaryOnly || uploadTo != "" || encrypt != "" || groupBy != "" || withTrailer) {
+		panic(fmt.Errorf("-watch cannot be combined with verifying, -compare, -summary-only, -upload, -encrypt, -group-by or -trailer"))
+	}
+	if stallTimeout < 0 {
+		panic(fmt.Errorf("-stall-timeout must not be negative"))
+	}
+	if skipStalled && stallTimeout == 0 {
+		panic(fmt.Errorf("-skip-stalled needs -stall-timeout"))
+	}
+	if filesFrom != "" && (firstFrom != "" || verifying || compareWith != "" || subtreeJobs > 0) {
+		panic(fmt.Errorf("-files-from cannot be combined with -first-from, -subtree-jobs, -compare or verifying"))
+	}
+	if bestOf < 0 || bestOf == 1 {
+		panic(fmt.Errorf("-best-of must be at least 2"))
+	}
+	if bestOf > 0 && doubleRead {
+		panic(fmt.Errorf("-best-of and -double-read cannot be combined"))
+	}
+	if bestOf > 0 && len(algos) > 1 {
+		// the further digests would be those of the first read
+		panic(fmt.Errorf("-best-of cannot be combined with several -algo"))
+	}
+	if signDetached && (signKey == nil || outputPath == "" || encrypt != "") {
+		// the signature is of the plain manifest
+		panic(fmt.Errorf("-sign-detached needs -sign-key and -output and cannot be combined with -encrypt"))
+	}
+	if signKey != nil && !signDetached && !recordFormat.comments {
+		panic(fmt.Errorf("-sign-key needs a -format with comments, or -sign-detached"))
+	}
+	if signKey != nil && (appendOutput || watchInterval > 0) {
+		// either would add to a signed manifest
+		panic(fmt.Errorf("-sign-key cannot be combined with -append or -watch"))
+	}
+	if verifySig != (pubkey != nil) || (verifySig && verifyPath == "") {
+		panic(fmt.Errorf("-verify-sig needs -pubkey and -verify, and -pubkey only applies to -verify-sig"))
+	}
+	if imagePartitions && (len(images) == 0 || !recordFormat.comments) {
+		panic(fmt.Errorf("-image-partitions needs -image and a -format with comments"))
+	}
+	if imageExtent < 0 {
+		panic(fmt.Errorf("-image-extent must not be negative"))
+	}
+	if imageExtent > 0 && (len(images) == 0 || !recordFormat.comments) {
+		panic(fmt.Errorf("-image-extent needs -image and a -format with comments"))
+	}
+	if imageStatePath != "" && len(images) == 0 {
+		panic(fmt.Errorf("-image-state needs -image"))
+	}
+	if len(images) > 0 && verifying {
+		panic(fmt.Errorf("-image doesn't apply when verifying, -verify checks images listed in the manifest"))
+	}
+	if withTreeDigests && !recordFormat.comments {
+		panic(fmt.Errorf("-tree-digests cannot be combined with -format %s", format))
+	}
+	if hmacKeyFile != "" && (format == "gnu" || format == "gnu-binary") {
+		// md5sum would take them for plain digests
+		panic(fmt.Errorf("-hmac-key-file cannot be combined with -format %s", format))
+	}
+	if chunkSize > 0 && (format == "gnu" || format == "gnu-binary") {
+		// md5sum would take them for plain digests
+		panic(fmt.Errorf("-chunk-size cannot be combined with -format %s", format))
+	}
+	if _, plain := hashes[primary]; perDirManifest != "" && !plain {
+		// they are written in the format of md5sum, which would take
+		// them for plain digests
+		panic(fmt.Errorf("-per-dir-manifest cannot be combined with -hmac-key-file, -chunk-size or -algo %s", primary))
+	}
+	if byLength, _ := algoForDigest(make([]byte, hashes[primary]().Size())); perDirManifest != "" && byLength != primary {
+		// -verify-sidecars tells their algorithm by the length of the
+		// digests
+		panic(fmt.Errorf("-per-dir-manifest cannot be combined with -algo %s, whose digests are as long as %s ones", primary, byLength))
+	}
+	if len(algos) > 1 && (format == "csv" || format == "gnu" || format == "gnu-binary" || groupBy != "") {
+		panic(fmt.Errorf("-algo with several algorithms cannot be combined with -format %s or -group-by", format))
+	}
+	if groupBy != "" && format != "plain" {
+		panic(fmt.Errorf("-group-by can only be combined with -format plain"))
+	}
+	if sortBuffer < 0 {
+		panic(fmt.Errorf("-sort-buffer must not be negative"))
+	}
+	if sortBuffer > 0 && (noSort || groupBy != "") {
+		panic(fmt.Errorf("-sort-buffer cannot be combined with -no-sort or -group-by"))
+	}
+	if groupBy != "" && noSort {
+		panic(fmt.Errorf("-group-by needs all checksums and cannot be combined with -no-sort"))
+	}
+
+	if len(redactPatterns) > 0 {
+		redaction = &redactor{mask: redactMask}
+		for _, p := range redactPatterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				panic(fmt.Errorf("invalid -redact-pattern '%s': %v", p, err))
+			}
+			redaction.patterns = append(redaction.patterns, re)
+		}
+		log.SetOutput(redactOutput(os.Stderr))
+		reports = redactOutput(os.Stdout)
+		// a panic would print the unredacted error, report it through
+		// the log instead
+		defer func() {
+			if r := recover(); r != nil {
+				log.Print(r)
+				os.Exit(exitError)
+			}
+		}()
+	}
+
+	if accessLogPath != "" {
+		if accessLog, err = newAccessLogger(accessLogPath); err != nil {
+			panic(fmt.Errorf("cannot open access log '%s': %v", accessLogPath, err))
+		}
+		defer accessLog.close()
+	}
 
-	// expand paths like "." and "./foo" to "/home" and "/home/foo"
-	rootdir, err := filepath.Abs(rootdir)
+	var source Source = localSource{}
+	if followSymlinks && hashLinkText {
+		panic(fmt.Errorf("-follow-symlinks and -hash-link-target-path cannot be combined"))
+	} else if followSymlinks {
+		source = followSource{}
+	} else if hashLinkText {
+		source = linkTextSource{}
+	}
+	// -compare reads local trees the same way
+	localTrees := source
+	// the root itself may be a symbolic link
+	statRoot := os.Stat
+	remote, isRemote, err := remoteSource(rootdir)
 	if err != nil {
-		panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", rootdir, err))
+		panic(fmt.Errorf("cannot read '%s': %v", rootdir, err))
+	}
+	if isRemote {
+		if perDirManifest != "" || firstFrom != "" || filesFrom != "" || verifying {
+			panic(fmt.Errorf("-per-dir-manifest, -first-from, -files-from and verifying need a local -dir"))
+		}
+		if followSymlinks || hashLinkText {
+			panic(fmt.Errorf("-follow-symlinks and -hash-link-target-path need a local -dir"))
+		}
+		if withTreeDigests {
+			panic(fmt.Errorf("-tree-digests needs a local -dir"))
+		}
+		if len(rescueMapFiles) > 0 {
+			panic(fmt.Errorf("-ddrescue-map needs a local -dir"))
+		}
+		source = remote
+		if noTrustRemote {
+			source = untrustedSource{source}
+		}
+		statRoot = source.Stat
+		if !strings.HasSuffix(rootdir, "://") {
+			rootdir = strings.TrimSuffix(rootdir, "/")
+		}
+	} else {
+		// expand paths like "." and "./foo" to "/home" and "/home/foo"
+		rootdir, err = filepath.Abs(rootdir)
+		if err != nil {
+			panic(fmt.Errorf("cannot expand '%s' to absolute path: %v", rootdir, err))
+		}
 	}
 
 	// check that the path exists
-	stat, err := os.Stat(rootdir)
+	stat, err := statRoot(rootdir)
 	if err != nil {
 		panic(fmt.Errorf("cannot stat '%s': %v", rootdir, err))
 	}
@@ -33,15 +606,610 @@ func main() {
 		panic(fmt.Errorf("%s is not a directory", rootdir))
 	}
 
-	checksums, err := walkPath(rootdir)
+	rescued, err := parseRescueMaps(rescueMapFiles, rootdir)
+	if err != nil {
+		panic(fmt.Errorf("-ddrescue-map: %v", err))
+	}
+
+	var suppress *suppressions
+	if suppressFile != "" {
+		if suppress, err = readSuppressions(suppressFile, rootdir, time.Now()); err != nil {
+			panic(fmt.Errorf("cannot read suppressions '%s': %v", suppressFile, err))
+		}
+	}
+
+	// writeStats writes the numbers of the run for -summary-json
+	writeStats := func(stats runStats) {
+		if summaryJSON == "" {
+			return
+		}
+		if err := stats.write(summaryJSON); err != nil {
+			log.Printf("could not write summary '%s': %v", summaryJSON, err)
+		}
+	}
+
+	// with -fail-fast, stop ends a verification at the first outcome
+	// that fails the run once suppressions and the policy are applied
+	var stop func(verification) bool
+	if failFast {
+		stop = func(v verification) bool {
+			if v.verdict == verdictOK || suppress.match(v.path) != nil {
+				return false
+			}
+			if pol != nil {
+				severity := pol.rule(rootdir, v.path).severity
+				return severity != severityIgnore && severity != severityInfo
+			}
+			return true
+		}
+	}
+
+	// report post-processes the outcome of a verification, reports it
+	// and exits
+	report := func(results []verification) {
+		if suppress != nil {
+			results = suppress.filter(results)
+			suppress.report()
+		}
+		if pol != nil {
+			results = pol.apply(results, rootdir)
+		}
+		if fsErrors {
+			correlateFSErrors(results, rootdir)
+		}
+		rescued.annotate(results)
+		if mismatch != nil {
+			mismatch.apply(results, rootdir, dryRun)
+		}
+		hooks.verified(results, rootdir)
+		writeStats(verificationStats(results, started))
+		reportVerifications(results)
+	}
+	if verifySidecarFiles {
+		results, err := verifySidecars(rootdir, perDirManifest, stop)
+		if err != nil {
+			panic(fmt.Errorf("could not walk '%s': %v", rootdir, err))
+		}
+		report(results)
+	}
+	if verifyJSON != "" {
+		results, err := verifyJSONList(verifyJSON, rootdir, stop)
+		if err != nil {
+			panic(fmt.Errorf("could not verify against '%s': %v", verifyJSON, err))
+		}
+		report(results)
+	}
+	if verifyPath != "" && verifySig {
+		if err := checkSignature(verifyPath, pubkey); err != nil {
+			panic(fmt.Errorf("cannot verify the signature of '%s': %v", verifyPath, err))
+		}
+		log.Printf("'%s' is signed with the key in '%s'", verifyPath, pubkeyFile)
+	}
+	if verifyPath != "" {
+		results, err := verifyManifest(verifyPath, rootdir, stop)
+		if err != nil {
+			panic(fmt.Errorf("could not verify against '%s': %v", verifyPath, err))
+		}
+		report(results)
+	}
+
+	if launchdLabel != "" {
+		if err := writeLaunchdPlist(os.Stdout, launchdLabel, launchdArgs()); err != nil {
+			panic(fmt.Errorf("could not write launchd job: %v", err))
+		}
+		return
+	}
+
+	if chaosSpec != "" {
+		c, err := parseChaos(chaosSpec)
+		if err != nil {
+			panic(fmt.Errorf("invalid -chaos: %v", err))
+		}
+		log.Printf("injecting faults into %.2g%% of reads", c.P*100)
+		source = ChaosSource(source, c)
+	}
+	opts := walkOptions{subtreeJobs: subtreeJobs, algo: primary, extraAlgos: algos[1:], source: source, doubleRead: doubleRead, bestOf: bestOf, sequential: sequential}
+	if bestOf > 0 {
+		opts.disagreements = &fileErrors{}
+	}
+	if keepGoing {
+		opts.failures = &fileErrors{}
+	}
+	if showProgress {
+		opts.progress, opts.scanFirst = newProgressBar(os.Stderr).update, true
+	}
+	filter, err := newPathFilter(include, exclude)
 	if err != nil {
-		panic(fmt.Errorf("could not calculate checksums: %v", err))
+		panic(fmt.Errorf("invalid -include or -exclude: %v", err))
+	}
+	if filter != nil {
+		opts.keepFile, opts.keepDir = filter.keepFile, filter.keepDir
+	}
+	if oneFileSystem {
+		dev, ok := deviceOf(stat)
+		if !ok {
+			panic(fmt.Errorf("-one-file-system needs a local -dir on a system with device IDs"))
+		}
+		// another device is another filesystem, mounted below the root
+		sameDevice := func(info os.FileInfo) bool {
+			d, ok := deviceOf(info)
+			return !ok || d == dev
+		}
+		keepFile, keepDir := opts.keepFile, opts.keepDir
+		opts.keepFile = func(rel string, info os.FileInfo) bool {
+			return sameDevice(info) && (keepFile == nil || keepFile(rel, info))
+		}
+		opts.keepDir = func(rel string, info os.FileInfo) bool {
+			return sameDevice(info) && (keepDir == nil || keepDir(rel, info))
+		}
+	}
+	if compareWith != "" {
+		compareOpts := opts
+		compareOpts.source, compareOpts.extraAlgos = localTrees, nil
+		results := compareTrees(rootdir, compareWith, compareOpts, noTrustRemote, stop)
+		writeStats(verificationStats(results, started))
+		if format == "json" {
+			reportVerificationsJSON(results)
+		}
+		reportVerifications(results)
+	}
+	if cachePath != "" {
+		if opts.cache, err = loadStateCache(cachePath); err != nil {
+			panic(fmt.Errorf("cannot read cache '%s': %v", cachePath, err))
+		}
+	}
+	if watchInterval > 0 && opts.cache == nil {
+		// keeps the files that didn't change from being read again
+		opts.cache = newStateCache()
+	}
+	if firstFrom != "" {
+		if opts.first, err = readPathList(firstFrom); err != nil {
+			panic(fmt.Errorf("cannot read '%s': %v", firstFrom, err))
+		}
+	}
+	if filesFrom != "" {
+		if opts.first, err = readFileList(filesFrom); err != nil {
+			panic(fmt.Errorf("cannot read '%s': %v", filesFrom, err))
+		}
+		opts.listOnly = true
+	}
+
+	if perDirManifest != "" {
+		// manifests from earlier runs must not end up in the new ones
+		opts.excludeNames = append(opts.excludeNames, perDirManifest)
+	}
+	if ifChanged != "" {
+		unchanged, err := unchangedSince(ifChanged, opts.source, rootdir, opts)
+		if err != nil {
+			panic(fmt.Errorf("cannot read manifest '%s': %v", ifChanged, err))
+		}
+		if unchanged {
+			log.Printf("nothing changed since '%s' was written", ifChanged)
+			return
+		}
+	}
+
+	// notify is called once with the outcome of the run
+	notify := func(title string) {}
+	if notifyDesktop {
+		notify = func(title string) {
+			if err := desktopNotify(title, rootdir); err != nil {
+				log.Printf("could not show desktop notification: %v", err)
+			}
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				notify("md5summer failed")
+				panic(r)
+			}
+			notify("md5summer finished")
+		}()
+	}
+
+	st := newStatus()
+	// the first SIGINT or SIGTERM stops the walk from starting new files
+	// and lets the ones being read finish, a second one stops reading
+	// them too. Either way the output is written for the files done so
+	// far. A third one quits right away.
+	var interrupted atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// with -watch, the first signal ends the watch
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, finishing the files being read", sig)
+		interrupted.Store(true)
+		st.abort()
+		stopWatch()
+		sig = <-signals
+		signal.Stop(signals)
+		log.Printf("received %v, abandoning the files being read", sig)
+		cancel()
+	}()
+	// set once an interrupted walk is over, the output is incomplete
+	partial := false
+	stopTUI := func() {}
+	if useTUI {
+		stopTUI = startTUI(st)
+		defer stopTUI()
+	}
+	// streamed results are kept if they are needed once the walk is over
+	keepStreamed := perDirManifest != "" || uploadTo != "" || splitOutput != "" || watchInterval > 0 || withTreeDigests
+	// walk calculates the checksums and deals with a failed walk
+	// the images of -image, with their partitions
+	var imaged []diskImage
+	imageOpts := imageOptions{algo: primary, extraAlgos: opts.extraAlgos, partitions: imagePartitions, extent: imageExtent, progress: opts.progress}
+	if imageStatePath != "" {
+		if imageOpts.states, err = loadImageStates(imageStatePath); err != nil {
+			panic(fmt.Errorf("cannot read '%s': %v", imageStatePath, err))
+		}
+	}
+	walk := func(acc *checksums) []checksum {
+		acc.dups, acc.folds = dups, folds
+		var streamed []checksum
+		if keepStreamed && acc.stream != nil {
+			stream := acc.stream
+			acc.stream = func(cs checksum) {
+				streamed = append(streamed, cs)
+				stream(cs)
+			}
+		}
+		stopWatchdog := func() {}
+		if stallTimeout > 0 {
+			stopWatchdog = watchStalls(st, stallTimeout, skipStalled)
+		}
+		for _, path := range images {
+			cs, image, err := hashImage(ctx, path, imageOpts, st)
+			if ctx.Err() != nil || err == errAborted {
+				// the walk below finds out, too
+				break
+			}
+			if err != nil && opts.failures != nil {
+				opts.failures.add(path, err)
+				continue
+			}
+			if err != nil {
+				panic(fmt.Errorf("could not checksum image '%s': %v", path, err))
+			}
+			acc.add(cs)
+			imaged = append(imaged, image)
+		}
+		sums, err := walkPath(ctx, rootdir, acc, st, opts)
+		stopWatchdog()
+		stopTUI()
+		switch {
+		case err == errAborted && interrupted.Load(), err == context.Canceled:
+			partial = true
+		case err == errAborted:
+			log.Print("aborted by user")
+			notify("md5summer aborted")
+			os.Exit(exitError)
+		case err != nil:
+			panic(fmt.Errorf("could not calculate checksums: %v", err))
+		}
+		if dups != nil && dups.err != nil {
+			panic(fmt.Errorf("could not calculate checksums: %v", dups.err))
+		}
+		if cachePath != "" {
+			// an interrupted run didn't come across all files, a
+			// filtered one was never meant to
+			prune := !partial && opts.keepFile == nil && opts.keepDir == nil && len(opts.first) == 0
+			if err := opts.cache.save(cachePath, rootdir, prune); err != nil {
+				log.Printf("could not save cache '%s': %v", cachePath, err)
+			}
+		}
+		if acc.stream != nil {
+			sums = streamed
+		}
+		if partial {
+			// manifests of their own would silently lack files
+			return sums
+		}
+		if perDirManifest != "" {
+			if err := writePerDirManifests(perDirManifest, sums); err != nil {
+				panic(fmt.Errorf("could not write per-directory manifests: %v", err))
+			}
+		}
+		if splitOutput != "" {
+			if err := writeSplitManifests(splitOutput, rootdir, sums, splitEntries, recordFormat); err != nil {
+				panic(fmt.Errorf("could not write split manifests: %v", err))
+			}
+		}
+		return sums
+	}
+
+	if summaryOnly {
+		checksums := walk(&checksums{})
+		if err := summarize(checksums, rootdir, opts.failures.count()).writeTo(os.Stdout); err != nil {
+			panic(fmt.Errorf("could not write summary: %v", err))
+		}
+		writeStats(walkStats(st, opts.failures, partial))
+		if partial {
+			log.Print("interrupted, the summary covers only the files finished")
+			os.Exit(exitInterrupted)
+		}
+		reportFailures(opts.failures)
+		return
+	}
+	if dupes {
+		checksums := walk(&checksums{})
+		if err := writeDupes(os.Stdout, findDupes(checksums, dupesMinSize, statRoot)); err != nil {
+			panic(fmt.Errorf("could not write duplicates: %v", err))
+		}
+		writeStats(walkStats(st, opts.failures, partial))
+		if partial {
+			log.Print("interrupted, the report covers only the files finished")
+			os.Exit(exitInterrupted)
+		}
+		reportFailures(opts.failures)
+		return
+	}
+
+	// the manifest is spooled to a temporary file as it is written when
+	// it is uploaded afterwards
+	var stdout io.Writer = os.Stdout
+	var output *atomicOutput
+	if outputPath != "" {
+		if output, err = createOutput(outputPath, appendOutput); err != nil {
+			panic(fmt.Errorf("cannot write '%s': %v", outputPath, err))
+		}
+		defer output.abort()
+		stdout = output
+	}
+	var spool *os.File
+	if uploadTo != "" {
+		if spool, err = os.CreateTemp("", "md5summer-*.txt"); err != nil {
+			panic(fmt.Errorf("cannot spool manifest for upload: %v", err))
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		stdout = io.MultiWriter(stdout, spool)
+	}
+	// the whole manifest, header and trailer included, is encrypted, and
+	// so is the copy that is uploaded
+	var encrypted *encryptWriter
+	if encryptKey != nil {
+		if encrypted, err = newEncryptWriter(stdout, encryptKey); err != nil {
+			panic(fmt.Errorf("cannot encrypt manifest: %v", err))
+		}
+		stdout = encrypted
+	}
+	// the signature is of the plain manifest, and encrypted along with it
+	var signer *signWriter
+	if signKey != nil {
+		signer = newSignWriter(stdout, signKey)
+		stdout = signer
+	}
+	// signature is the signature line for -sign-detached
+	signature := ""
+	writeSignature := func(path string) {
+		if err := writeAtomic(path+".sig", func(w io.Writer) error {
+			_, err := io.WriteString(w, signature)
+			return err
+		}); err != nil {
+			panic(fmt.Errorf("could not write signature: %v", err))
+		}
+	}
+
+	// with -since, records matching the earlier manifest are left out
+	var previous map[string][]byte
+	if since != "" {
+		var previousAlgo string
+		if previous, previousAlgo, err = readManifest(since); err != nil {
+			panic(fmt.Errorf("cannot read manifest '%s': %v", since, err))
+		}
+		if previousAlgo != "" && previousAlgo != primary {
+			panic(fmt.Errorf("'%s' holds %s digests, run with -algo %s to compare with it", since, previousAlgo, previousAlgo))
+		}
+		// relative paths, as md5sum writes them, are relative to -dir
+		previous = resolvePaths(previous, rootdir, isRemote)
+	}
+	// with -append, the files already in the manifest keep their records
+	var appended map[string][]byte
+	if appendOutput {
+		var appendedAlgo string
+		appended, appendedAlgo, err = readManifest(outputPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			panic(fmt.Errorf("cannot append to '%s': %v", outputPath, err))
+		}
+		if appendedAlgo != "" && appendedAlgo != primary {
+			panic(fmt.Errorf("'%s' holds %s digests, run with -algo %s to append to it", outputPath, appendedAlgo, appendedAlgo))
+		}
+	}
+	changes := &sinceFilter{previous: previous, appended: appended, suppress: suppress}
+
+	// hash the records as they are written so the trailer can vouch for them
+	body := md5.New()
+	out := io.MultiWriter(stdout, body)
+	records := &recordWriter{w: out, format: recordFormat}
+	emit := func(cs checksum) {
+		if changes.keep(cs) {
+			records.write(&cs)
+		}
+	}
+
+	var all []checksum
+	if noSort {
+		// stream results as they are calculated, the number of
+		// entries is only known once the walk is over
+		if withHeader {
+			if err := newHeader(rootdir, primary, -1).writeTo(stdout); err != nil {
+				panic(fmt.Errorf("could not write header: %v", err))
+			}
+		}
+		all = walk(&checksums{stream: emit})
+	} else if sortBuffer > 0 {
+		// the number of entries is only known once they are merged
+		if withHeader {
+			if err := newHeader(rootdir, primary, -1).writeTo(stdout); err != nil {
+				panic(fmt.Errorf("could not write header: %v", err))
+			}
+		}
+		sorter := &spillSorter{less: less, limit: sortBuffer}
+		all = walk(&checksums{stream: sorter.add})
+		if err := sorter.each(emit); err != nil {
+			panic(fmt.Errorf("could not sort checksums: %v", err))
+		}
+	} else {
+		checksums := walk(&checksums{less: less})
+		all = checksums
+		if changes.active() {
+			var changed []checksum
+			for _, cs := range checksums {
+				if changes.keep(cs) {
+					changed = append(changed, cs)
+				}
+			}
+			checksums = changed
+		}
+		if withHeader {
+			if err := newHeader(rootdir, primary, len(checksums)).writeTo(stdout); err != nil {
+				panic(fmt.Errorf("could not write header: %v", err))
+			}
+		}
+		if groupBy == "hash" {
+			for _, g := range groupByDigest(checksums) {
+				g.writeTo(out)
+			}
+			records.n = len(checksums)
+		} else {
+			for _, checksum := range checksums {
+				records.write(&checksum)
+			}
+		}
+	}
+	if groupBy == "" {
+		records.close()
+	}
+	if opts.failures != nil && recordFormat.comments {
+		// the files are missing, say why
+		for _, f := range opts.failures.list() {
+			fmt.Fprintln(stdout, headerPrefix+"error "+f.String())
+		}
+	}
+	if withTreeDigests && !partial {
+		// a tree missing files has no meaningful digests
+		dirs, err := treeDigests(rootdir, all, primary)
+		if err != nil {
+			panic(fmt.Errorf("could not calculate tree digests: %v", err))
+		}
+		for _, dir := range dirs {
+			fmt.Fprintln(stdout, headerPrefix+"tree "+dir.String())
+		}
+	}
+	for _, image := range imaged {
+		for _, p := range image.partitions {
+			fmt.Fprintln(stdout, headerPrefix+"partition "+p.String()+" "+image.path)
+		}
+		image.writeExtents(stdout)
+	}
+	if opts.disagreements != nil {
+		for _, d := range opts.disagreements.list() {
+			log.Printf("reads disagree on %s", d)
+			if recordFormat.comments {
+				fmt.Fprintln(stdout, headerPrefix+"disagreement "+d.String())
+			}
+		}
+	}
+	for _, group := range folds.collisions() {
+		log.Printf("'%s' differ only by case and collide on case-insensitive filesystems", strings.Join(group, "', '"))
+		if recordFormat.comments {
+			for _, path := range group {
+				fmt.Fprintln(stdout, headerPrefix+"case-collision "+path)
+			}
+		}
+	}
+	rescued.writePieces(stdout, primary, recordFormat.comments)
+	suppress.report()
+	if partial {
+		// files that weren't reached yet aren't gone
+		changes.present = len(previous)
+		if recordFormat.comments {
+			fmt.Fprintln(stdout, headerPrefix+"partial")
+		}
+	}
+	if removed := changes.removed(); removed > 0 {
+		// removals can't be expressed as records, mention them instead
+		log.Printf("%d files in '%s' no longer exist", removed, since)
+	}
+	if withTrailer {
+		if err := (trailer{records.n, body.Sum(nil)}).writeTo(stdout); err != nil {
+			panic(fmt.Errorf("could not write trailer: %v", err))
+		}
+	}
+	if signer != nil {
+		if signature, err = signer.signature(); err != nil {
+			panic(fmt.Errorf("could not sign manifest: %v", err))
+		}
+		if !signDetached {
+			// the signature doesn't sign itself
+			io.WriteString(signer.w, signature)
+		}
+	}
+	if encrypted != nil {
+		if err := encrypted.Close(); err != nil {
+			panic(fmt.Errorf("could not write manifest: %v", err))
+		}
 	}
-	for _, checksum := range checksums {
-		fmt.Println(checksum.String())
+	if partial {
+		log.Print("interrupted, the output covers only the files finished")
+		if spool != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		if output != nil {
+			// the manifest it was to replace may well be more complete
+			output.path += ".partial"
+			if err := output.commit(); err != nil {
+				log.Printf("could not write '%s': %v", output.path, err)
+			} else if signDetached {
+				writeSignature(output.path)
+			}
+		}
+		writeStats(walkStats(st, opts.failures, partial))
+		notify("md5summer interrupted")
+		os.Exit(exitInterrupted)
+	}
+	if output != nil {
+		if err := output.commit(); err != nil {
+			panic(fmt.Errorf("could not write '%s': %v", outputPath, err))
+		}
+		if signDetached {
+			writeSignature(outputPath)
+		}
+	}
+	if uploadTo != "" {
+		if err := uploadRun(uploadTo, spool.Name(), summarize(all, rootdir, opts.failures.count()), time.Now(), keepRuns); err != nil {
+			panic(fmt.Errorf("could not upload manifest: %v", err))
+		}
 	}
+	if watchInterval > 0 {
+		changed := func(cs checksum) {
+			fmt.Fprintln(stdout, recordFormat.record(&cs))
+		}
+		removed := func(path string) {
+			fmt.Fprintln(stdout, headerPrefix+"removed "+path)
+		}
+		watchTree(watchCtx, rootdir, all, st, opts, watchInterval, changed, removed)
+		log.Printf("stopped watching '%s'", rootdir)
+	}
+	writeStats(walkStats(st, opts.failures, partial))
+	reportFailures(opts.failures)
 }
 
+// reservedFiles is the number of open files left for what isn't read by
+// workers: the directories being listed, the output, logs, connections.
+const reservedFiles = 64
+
+// numWorkers is the number of files read concurrently, set by -workers.
+// Reading mostly waits for the disk, so there are more workers than CPUs
+// on small machines.
+var numWorkers = max(runtime.NumCPU(), 10)
+
 type ctrl struct {
 	// used to accumulate our results
 	acc *checksums
@@ -51,6 +1219,30 @@ type ctrl struct {
 	throttle throttle
 	// used to wait for goroutines to exit
 	wg *sync.WaitGroup
+	// used to follow, pause and abort the walk
+	status *status
+	// cancels the walk, including the files being read
+	ctx context.Context
+	// where the files are read from
+	source Source
+	// digests of earlier runs, nil if there are none
+	cache *stateCache
+	// the clock
+	now func() time.Time
+	// files that couldn't be read, nil if the walk stops at the first
+	failures *fileErrors
+	// hash algorithm of the checksums
+	algo string
+	// algorithms of further digests calculated from the same read
+	extraAlgos []string
+	// read every file a second time and compare the digests
+	doubleRead bool
+	// read every file this many times and take the majority digest
+	bestOf int
+	// files whose reads disagreed with -best-of
+	disagreements *fileErrors
+	// read every file front to back in large reads
+	sequential bool
 }
 
 type throttle chan struct{}
@@ -66,27 +1258,182 @@ func newThrottle(n int) throttle {
 func (t throttle) wait()  { <-t }
 func (t throttle) ready() { t <- struct{}{} }
 
-func walkPath(path string) ([]checksum, error) {
-	const numWorkers = 10
+// walkOptions configure a walk.
+type walkOptions struct {
+	// files to checksum before walking the tree, in this order. Relative
+	// paths are relative to the root.
+	first []string
+	// if set, only the files in first are checksummed, the tree isn't
+	// walked
+	listOnly bool
+	// base names of files that are never checksummed
+	excludeNames []string
+	// if set, only the files it returns true for are checksummed. It is
+	// given paths relative to the root, slash separated, so the same
+	// hook serves walks of different roots.
+	keepFile func(rel string, info os.FileInfo) bool
+	// if set, the directories below the root it returns false for are
+	// skipped with everything in them, it is given paths like keepFile
+	keepDir func(rel string, info os.FileInfo) bool
+	// called in its own goroutine for every file instead of
+	// checksumFile, it must release the worker like checksumFile does
+	process func(path string, info os.FileInfo, c ctrl)
+	// if above 0, every top-level directory is walked in a walk of its
+	// own, this many at a time
+	subtreeJobs int
+	// where the files are read from, the local filesystem if nil
+	source Source
+	// hash algorithm of the checksums, md5 if empty
+	algo string
+	// algorithms of further digests of every file, calculated from the
+	// same read
+	extraAlgos []string
+	// if set, every file is read twice and fails if the digests differ
+	doubleRead bool
+	// if above 1, every file is read this many times, and gets the digest
+	// more than half of the reads agree on
+	bestOf int
+	// with bestOf, the files whose reads disagreed are collected here,
+	// with what each read gave
+	disagreements *fileErrors
+	// number of files read at a time, numWorkers if 0
+	workers int
+	// if set, the files are read one at a time, in the order they are
+	// stored in on the medium, in large reads
+	sequential bool
+	// digests of earlier runs to reuse for unchanged files, if set
+	cache *stateCache
+	// the clock, time.Now if nil
+	now func() time.Time
+	// if set, called with the progress of the walk every
+	// progressInterval and once it is over
+	progress func(Progress)
+	// with progress, scan the tree before the walk so the totals are
+	// known
+	scanFirst bool
+	// if set, files and directories that can't be read are collected
+	// here and the walk goes on without them
+	failures *fileErrors
+}
 
+// walkPath calculates the checksums of all files below path and collects
+// them in acc, reporting its progress through st. If st is aborted, the
+// checksums finished until then are returned along with errAborted, and
+// if ctx is cancelled, along with the error of ctx. Unlike aborting,
+// cancelling stops reading the files being read.
+func walkPath(ctx context.Context, path string, acc *checksums, st *status, opts walkOptions) ([]checksum, error) {
+	root := path
+	workers := opts.workers
+	if workers == 0 {
+		workers = numWorkers
+	}
+	if opts.sequential {
+		workers = 1
+	}
+	src := opts.source
+	if src == nil {
+		src = localSource{}
+	}
 	// setup the control structure
 	c := ctrl{
-		&checksums{},
+		acc,
 		make(chan error, 1),
-		newThrottle(numWorkers),
+		newThrottle(workers),
 		&sync.WaitGroup{},
+		st,
+		ctx,
+		src,
+		opts.cache,
+		opts.now,
+		opts.failures,
+		opts.algo,
+		opts.extraAlgos,
+		opts.doubleRead,
+		opts.bestOf,
+		opts.disagreements,
+		opts.sequential,
+	}
+	if c.bestOf > 1 && c.disagreements == nil {
+		c.disagreements = &fileErrors{}
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+	if c.algo == "" {
+		c.algo = "md5"
+	}
+	if opts.sequential {
+		// the walk below only picks up what wasn't there yet
+		opts.first = mediaOrder(src, root, opts)
+	}
+	if opts.progress != nil {
+		var files, bytes int64
+		if opts.scanFirst {
+			files, bytes = scanTotals(src, root, opts)
+			// what was done before the walk, such as -image, counts too
+			files += st.files.Load()
+			bytes += st.bytes.Load()
+		}
+		defer reportProgress(st, files, bytes, c.now, opts.progress)()
+	}
+	// paused readers have to wake up to notice the cancellation
+	defer context.AfterFunc(ctx, func() { st.setPaused(false) })()
+
+	process := checksumFile
+	if opts.process != nil {
+		process = opts.process
 	}
 
 	// fn is our os.WalkFunc, it will be called for every file and directory.
 	// It starts a goroutine for every file that calculates the file's checksum.
 	fn := func(path string, info os.FileInfo, err error) error {
+		if info == nil {
+			// not even its type is known
+			if opts.failures != nil && path != root {
+				opts.failures.add(path, err)
+				return nil
+			}
+			return err
+		}
+		rel := ""
+		if opts.keepFile != nil || opts.keepDir != nil {
+			rel, _ = filepath.Rel(root, path)
+			rel = filepath.ToSlash(rel)
+		}
 		if info.IsDir() {
+			if path != root && opts.keepDir != nil && !opts.keepDir(rel, info) {
+				return filepath.SkipDir
+			}
 			// we don't checksum directories, only files
+			accessLog.record("list", path, 0, err)
+			if err != nil && opts.failures != nil {
+				// the files in it are missing from the output
+				opts.failures.add(path, err)
+			}
+			return nil
+		}
+		for _, name := range opts.excludeNames {
+			if info.Name() == name {
+				return nil
+			}
+		}
+		if opts.keepFile != nil && !opts.keepFile(rel, info) {
 			return nil
 		}
 		if err != nil {
+			if opts.failures != nil {
+				opts.failures.add(path, err)
+				return nil
+			}
+			return err
+		}
+		// has the user given up on the walk?
+		if err := c.ctx.Err(); err != nil {
 			return err
 		}
+		if c.status.aborted.Load() {
+			return errAborted
+		}
 		// have any workers returned errors?
 		select {
 		case err = <-c.errs:
@@ -98,11 +1445,69 @@ func walkPath(path string) ([]checksum, error) {
 		// wait for a worker to exit
 		c.throttle.wait()
 		c.wg.Add(1)
-		go checksumFile(path, c)
+		go process(path, info, c)
 		return nil
 	}
-	err := filepath.Walk(path, fn)
+
+	// start with the files that were asked for first and remember them,
+	// so the walk doesn't checksum them a second time
+	done := make(map[string]bool)
+	for _, first := range opts.first {
+		first, ok := belowRoot(path, first)
+		if !ok {
+			log.Printf("ignoring %s, it is not below %s", first, path)
+			continue
+		}
+		info, err := src.Stat(first)
+		if err != nil && !opts.listOnly {
+			log.Printf("ignoring %s: %v", first, err)
+			continue
+		}
+		// files listed for -files-from that can't be read are failures
+		if err == nil && (!info.Mode().IsRegular() || done[first]) {
+			continue
+		}
+		done[first] = true
+		if err := fn(first, info, err); err == errAborted {
+			// the walk below returns what is done so far
+			break
+		} else if err != nil {
+			c.wg.Wait()
+			return nil, err
+		}
+	}
+	walkFn := fn
+	if len(done) > 0 {
+		walkFn = func(path string, info os.FileInfo, err error) error {
+			if done[path] {
+				return nil
+			}
+			return fn(path, info, err)
+		}
+	}
+	var err error
+	switch {
+	case opts.listOnly:
+		// the files listed are all there is
+	case opts.subtreeJobs > 0:
+		err = walkSubtrees(path, walkFn, opts.subtreeJobs, c)
+	default:
+		err = src.Walk(path, walkFn)
+	}
 	c.wg.Wait()
+	if ctx.Err() != nil {
+		// whatever the walk or the workers failed with, it was
+		// because of the cancellation
+		return c.acc.checksums(), ctx.Err()
+	}
+	if err == nil && c.status.aborted.Load() {
+		// the user aborted after the last file was started
+		err = errAborted
+	}
+	if err == errAborted {
+		// what was finished before is as good as in a complete walk
+		return c.acc.checksums(), err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -119,24 +1524,125 @@ func walkPath(path string) ([]checksum, error) {
 	return c.acc.checksums(), nil
 }
 
-func checksumFile(path string, c ctrl) {
+// belowRoot returns the clean absolute form of path, relative paths
+// being relative to root, and whether it is below root.
+func belowRoot(root, path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(root, path)
+	return path, err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// walkSubtrees walks every top-level directory below root in a walk of
+// its own, up to jobs at a time, and the files directly in root in the
+// calling goroutine. On filers where listing a huge directory serializes
+// a single walker, the others keep the workers busy meanwhile. All walks
+// share the workers of c, so no more files are read at once than without.
+func walkSubtrees(root string, walkFn filepath.WalkFunc, jobs int, c ctrl) error {
+	var subtrees sync.WaitGroup
+	slots := newThrottle(jobs)
+	err := c.source.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == root {
+			return walkFn(path, info, err)
+		}
+		slots.wait()
+		subtrees.Add(1)
+		go func() {
+			defer subtrees.Done()
+			defer slots.ready()
+			if err := c.source.Walk(path, walkFn); err != nil {
+				// the other walks pick the error up and stop too
+				notifyErr(c, err)
+			}
+		}()
+		return filepath.SkipDir
+	})
+	subtrees.Wait()
+	return err
+}
+
+func checksumFile(path string, info os.FileInfo, c ctrl) {
 	defer c.wg.Done()
 	defer c.throttle.ready()
+	// the source may know the digest already, which saves reading a
+	// file that is only reachable over the network. Neither it nor the
+	// cache know more than one digest.
+	if hr, ok := c.source.(hashReporter); ok && len(c.extraAlgos) == 0 && !c.doubleRead && c.bestOf == 0 {
+		if sum, ok := hr.Hash(path, c.algo); ok {
+			c.status.reused(info.Size())
+			c.acc.add(checksum{path, sum, info.Size(), info.ModTime(), c.algo, nil})
+			return
+		}
+	}
+	// neither is a file that didn't change since an earlier run
+	if sum, ok := c.cache.lookup(path, info, c.algo); ok && len(c.extraAlgos) == 0 && !c.doubleRead && c.bestOf == 0 {
+		c.status.reused(info.Size())
+		c.acc.add(checksum{path, sum, info.Size(), info.ModTime(), c.algo, nil})
+		return
+	}
+
 	// open the file
-	file, err := os.Open(path)
+	file, err := c.source.Open(path)
 	if err != nil {
-		notifyErr(c, err)
+		fileFailed(c, path, err)
 		return
 	}
 	defer file.Close()
 
 	// checksum its contents
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	af := c.status.start(path, info.Size())
+	read := func(r io.Reader) io.Reader { return progressReader{contextReader{c.ctx, r}, af, c.status} }
+	hash, err := newHash(c.algo)
+	if err != nil {
+		c.status.finish(af, false)
 		notifyErr(c, err)
 		return
 	}
-	c.acc.add(checksum{path, hash.Sum(nil)})
+	// further digests are calculated from the same read
+	var w io.Writer = hash
+	var extra *multiHash
+	if len(c.extraAlgos) > 0 {
+		if extra, err = newMultiHash(c.extraAlgos); err != nil {
+			c.status.finish(af, false)
+			notifyErr(c, err)
+			return
+		}
+		w = io.MultiWriter(hash, extra)
+	}
+	var sum []byte
+	base, size, chunked := chunkedAlgo(c.algo)
+	ra, seekable := file.(io.ReaderAt)
+	// io.CopyBuffer allocates a buffer of its own if buf is nil
+	var buf []byte
+	if c.sequential {
+		buf = make([]byte, sequentialBuffer)
+	}
+	if chunked && seekable && info.Size() > size && extra == nil && !c.sequential {
+		// the chunks of a large file are read in parallel
+		sum, err = hashChunks(ra, info.Size(), size, hashes[base], read)
+	} else if _, err = io.CopyBuffer(w, read(file), buf); err == nil {
+		sum = hash.Sum(nil)
+	}
+	if err == nil && c.doubleRead {
+		err = checkReread(path, sum, af, c)
+	}
+	if err == nil && c.bestOf > 1 {
+		sum, err = majorityDigest(path, sum, af, c)
+	}
+	if err != nil {
+		c.status.finish(af, false)
+		if err == errSkipped {
+			log.Printf("skipped %s", path)
+			return
+		}
+		fileFailed(c, path, err)
+		return
+	}
+	c.status.finish(af, true)
+	c.cache.store(path, info, c.algo, sum, c.now())
+	c.acc.add(checksum{path, sum, info.Size(), info.ModTime(), c.algo, extra.digests()})
 }
 
 func notifyErr(c ctrl, err error) {
@@ -152,31 +1658,76 @@ func notifyErr(c ctrl, err error) {
 type checksum struct {
 	filepath string
 	sum      []byte
+	size     int64
+	mtime    time.Time
+	// name of the hash algorithm, a key of hashes
+	algo string
+	// digests in further algorithms, in the order they were asked for
+	extra []digest
+}
+
+// digest is a digest of a file in one of several algorithms.
+type digest struct {
+	algo string
+	sum  []byte
 }
 
+// String formats the record as the digest in base64, a space and the
+// path. Digests other than md5 are prefixed with their algorithm and a
+// colon, md5 ones are left bare as in manifests of older versions.
+// Further digests follow the first one, separated by spaces and always
+// prefixed.
 func (c *checksum) String() string {
-	return base64.StdEncoding.EncodeToString(c.sum) + " " + c.filepath
+	digest := base64.StdEncoding.EncodeToString(c.sum)
+	if c.algo != "md5" {
+		digest = c.algo + ":" + digest
+	}
+	for _, d := range c.extra {
+		digest += " " + d.algo + ":" + base64.StdEncoding.EncodeToString(d.sum)
+	}
+	return digest + " " + c.filepath
 }
 
 type checksums struct {
 	lk   sync.Mutex
 	sums []checksum
+	// order of the results, nil leaves them in the order they arrived
+	less lessFunc
+	// if set, results are passed on as they arrive instead of being kept
+	stream func(checksum)
+	// if set, decides about paths that arrive more than once
+	dups *duplicates
+	// if set, collects paths that differ only by case
+	folds *caseFolds
 }
 
 func (cs *checksums) add(sum checksum) {
 	cs.lk.Lock()
-	cs.sums = append(cs.sums, sum)
+	if cs.dups != nil && !cs.dups.keep(sum) {
+		cs.lk.Unlock()
+		return
+	}
+	if cs.folds != nil {
+		cs.folds.add(sum.filepath)
+	}
+	if cs.stream != nil {
+		cs.stream(sum)
+	} else {
+		cs.sums = append(cs.sums, sum)
+	}
 	cs.lk.Unlock()
 }
 
 func (cs *checksums) checksums() []checksum {
-	sort.Sort(cs)
+	if cs.less != nil {
+		sort.Sort(cs)
+	}
 	return cs.sums
 }
 
 func (cs *checksums) Len() int { return len(cs.sums) }
 func (cs *checksums) Less(i, j int) bool {
-	return cs.sums[i].filepath < cs.sums[j].filepath
+	return cs.less(&cs.sums[i], &cs.sums[j])
 }
 func (cs *checksums) Swap(i, j int) {
 	cs.sums[i], cs.sums[j] = cs.sums[j], cs.sums[i]
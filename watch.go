@@ -0,0 +1,82 @@
+package md5summer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// watchTree checksums root again every interval, until ctx is cancelled
+// or st aborted, starting from the checksums of a first walk with the
+// same opts. Files created or changed since the walk before are passed
+// to changed, files that are gone to removed, in the order of their
+// paths.
+//
+// The tree is polled rather than watched through the kernel, which works
+// the same everywhere, remote sources included. Only files whose size or
+// modification time changed are read again, opts.cache remembers the
+// rest, so it must be set.
+func watchTree(ctx context.Context, root string, known []checksum, st *status, opts walkOptions, interval time.Duration, changed func(checksum), removed func(path string)) {
+	sums := make(map[string]checksum, len(known))
+	for _, cs := range known {
+		sums[cs.filepath] = cs
+	}
+	// nobody is watching the progress of every poll
+	opts.progress = nil
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// a file may vanish between listing and reading it, that must
+		// not end the watch
+		failures := &fileErrors{}
+		opts.failures = failures
+		polled, err := walkPath(ctx, root, &checksums{}, st, opts)
+		if err == errAborted || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("could not checksum '%s': %v", root, err)
+			continue
+		}
+		current := make(map[string]checksum, len(polled))
+		for _, cs := range polled {
+			current[cs.filepath] = cs
+		}
+		for _, f := range failures.list() {
+			log.Printf("could not read %s", f)
+			// what is known about it still holds
+			if cs, ok := sums[f.path]; ok {
+				current[f.path] = cs
+			}
+		}
+		for _, path := range sortedPaths(current) {
+			if prev, ok := sums[path]; !ok || !bytes.Equal(prev.sum, current[path].sum) {
+				changed(current[path])
+			}
+		}
+		for _, path := range sortedPaths(sums) {
+			if _, ok := current[path]; !ok {
+				opts.cache.forget(path)
+				removed(path)
+			}
+		}
+		sums = current
+	}
+}
+
+// sortedPaths returns the paths of sums in lexicographical order.
+func sortedPaths(sums map[string]checksum) []string {
+	paths := make([]string, 0, len(sums))
+	for path := range sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,86 @@
+package md5summer
+
+import (
+	"log"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog looks at the files being
+// read.
+const watchdogInterval = 5 * time.Second
+
+// stallFactor is how many times longer than expected a file may take
+// before the watchdog reports it, what is expected following from its
+// size and the throughput of the run so far.
+const stallFactor = 10
+
+// readMark is how far a file had been read when the watchdog last saw it
+// progress.
+type readMark struct {
+	read int64
+	at   time.Time
+}
+
+// watchStalls reports files followed by st that made no progress for
+// timeout, or take far longer than their size and the throughput of the
+// run suggest, plus timeout. With skip, those files are skipped as if
+// the user had, which takes effect once the read they hang in returns.
+// Each file is reported once. It watches until the returned function is
+// called.
+func watchStalls(st *status, timeout time.Duration, skip bool) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+		marks := make(map[*activeFile]readMark)
+		reported := make(map[*activeFile]bool)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			rate := float64(st.bytes.Load()) / time.Since(st.started).Seconds()
+			active := make(map[*activeFile]bool)
+			for _, af := range st.activeFiles() {
+				active[af] = true
+				read := af.read.Load()
+				mark, ok := marks[af]
+				paused := st.isPaused()
+				if !ok || read != mark.read || paused {
+					mark = readMark{read, now}
+					marks[af] = mark
+				}
+				// a paused file isn't stuck
+				if reported[af] || paused {
+					continue
+				}
+				idle := now.Sub(mark.at)
+				took := now.Sub(af.started)
+				slow := rate > 0 && took > stallFactor*time.Duration(float64(af.size)/rate*float64(time.Second))+timeout
+				if idle < timeout && !slow {
+					continue
+				}
+				reported[af] = true
+				log.Printf("%s looks stuck: %s of %s read in %v, nothing for %v", af.path, humanBytes(read), humanBytes(af.size), took.Round(time.Second), idle.Round(time.Second))
+				if skip {
+					af.skip.Store(true)
+				}
+			}
+			// forget the files that are done
+			for af := range marks {
+				if !active[af] {
+					delete(marks, af)
+					delete(reported, af)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
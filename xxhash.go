@@ -0,0 +1,112 @@
+package md5summer
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH64 with seed 0, the xxhash digest xxhsum calculates by default,
+// written big-endian as xxhsum prints it. It is fast but not
+// cryptographic: it catches corruption, not tampering.
+
+// the primes of XXH64, variables so the arithmetic on them wraps
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+const xxStripe = 32
+
+type xxhash64 struct {
+	v     [4]uint64
+	total uint64
+	// the stripe not consumed yet
+	buf [xxStripe]byte
+	n   int
+}
+
+func newXXHash64() hash.Hash {
+	d := &xxhash64{}
+	d.Reset()
+	return d
+}
+
+func (d *xxhash64) Reset() {
+	d.v = [4]uint64{xxPrime1 + xxPrime2, xxPrime2, 0, -xxPrime1}
+	d.total = 0
+	d.n = 0
+}
+
+func (d *xxhash64) Size() int      { return 8 }
+func (d *xxhash64) BlockSize() int { return xxStripe }
+
+func (d *xxhash64) Write(p []byte) (int, error) {
+	n := len(p)
+	d.total += uint64(n)
+	if d.n > 0 {
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		if d.n < xxStripe {
+			return n, nil
+		}
+		d.stripe(d.buf[:])
+		d.n = 0
+	}
+	for len(p) >= xxStripe {
+		d.stripe(p[:xxStripe])
+		p = p[xxStripe:]
+	}
+	d.n = copy(d.buf[:], p)
+	return n, nil
+}
+
+func (d *xxhash64) stripe(p []byte) {
+	for i := range d.v {
+		d.v[i] = xxRound(d.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+	}
+}
+
+func (d *xxhash64) Sum(b []byte) []byte {
+	var h uint64
+	if d.total >= xxStripe {
+		h = bits.RotateLeft64(d.v[0], 1) + bits.RotateLeft64(d.v[1], 7) + bits.RotateLeft64(d.v[2], 12) + bits.RotateLeft64(d.v[3], 18)
+		for _, v := range d.v {
+			h = (h^xxRound(0, v))*xxPrime1 + xxPrime4
+		}
+	} else {
+		h = xxPrime5
+	}
+	h += d.total
+
+	p := d.buf[:d.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return binary.BigEndian.AppendUint64(b, h)
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}